@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+// moderatePost moves a post to the trash or back out of it, whoever wrote it.
+func (app *application) moderatePost(ctx context.Context, args []string, remove bool) error {
+	if len(args) != 1 {
+		return fmt.Errorf("posts delete and restore take a post ID: %w", errUsage)
+	}
+
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	status := "restored"
+	if remove {
+		status = "deleted"
+		err = app.models.Posts.Delete(ctx, id, 0, true)
+	} else {
+		err = app.models.Posts.Restore(ctx, id, 0, true)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return fmt.Errorf("post %d not found", id)
+		default:
+			return err
+		}
+	}
+
+	return app.writeMessage(map[string]any{"post_id": id, "status": status}, "post %d %s", id, status)
+}
+
+// moderateComment moves a comment to the trash or back out of it, whoever
+// wrote it.
+func (app *application) moderateComment(ctx context.Context, args []string, remove bool) error {
+	if len(args) != 2 {
+		return fmt.Errorf("comments delete and restore take a post ID and a comment ID: %w", errUsage)
+	}
+
+	postID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+
+	status := "restored"
+	if remove {
+		status = "deleted"
+		err = app.models.Comments.Delete(ctx, id, 0, postID, true)
+	} else {
+		err = app.models.Comments.Restore(ctx, id, 0, postID, true)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return fmt.Errorf("comment %d not found on post %d", id, postID)
+		default:
+			return err
+		}
+	}
+
+	return app.writeMessage(map[string]any{"post_id": postID, "comment_id": id, "status": status}, "comment %d on post %d %s", id, postID, status)
+}
+
+// mergeTags moves every post tagged with the first tag over to the second
+// one and deletes the first.
+func (app *application) mergeTags(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("tags merge takes the ID of the tag to merge and of the tag to merge it into: %w", errUsage)
+	}
+
+	sourceID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	targetID, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+
+	if sourceID == targetID {
+		return errors.New("a tag can't be merged into itself")
+	}
+
+	err = app.models.Tags.Merge(ctx, sourceID, targetID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return fmt.Errorf("tag %d or %d not found", sourceID, targetID)
+		default:
+			return err
+		}
+	}
+
+	tag, err := app.models.Tags.Get(ctx, targetID)
+	if err != nil {
+		return err
+	}
+
+	return app.write(tag, table{
+		header: []string{"ID", "NAME", "MERGED"},
+		rows:   [][]string{{fmt.Sprint(tag.ID), tag.Name, fmt.Sprint(sourceID)}},
+	})
+}
@@ -0,0 +1,154 @@
+// Command admin carries out administrative tasks directly against the
+// database: managing users and their tokens, moderating content and tidying
+// up tags.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+const usage = `usage: admin [flags] <command> [arguments]
+
+commands:
+  users list
+  users create -username NAME -email EMAIL [-role ROLE] [-activated]
+      reads the password from standard input, prompting for it on a terminal
+  users deactivate USER_ID
+  users role USER_ID ROLE
+  tokens revoke USER_ID
+  tokens purge [-batch-size N]
+  posts delete|restore POST_ID
+  comments delete|restore POST_ID COMMENT_ID
+  tags merge FROM_TAG_ID INTO_TAG_ID
+
+flags:`
+
+var errUsage = errors.New("run admin -h for usage")
+
+type config struct {
+	db struct {
+		dsn          string
+		queryTimeout time.Duration
+	}
+	json bool
+}
+
+type application struct {
+	models data.Models
+	in     io.Reader
+	out    io.Writer
+	json   bool
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("BLOGLY_DB_DSN"), "PostgreSQL DSN")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "PostgreSQL per-query timeout")
+	flag.BoolVar(&cfg.json, "json", false, "Write output as JSON instead of a table")
+
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	app := &application{
+		models: data.NewModels(db, cfg.db.queryTimeout),
+		in:     os.Stdin,
+		out:    os.Stdout,
+		json:   cfg.json,
+	}
+
+	err = app.run(context.Background(), flag.Args())
+	db.Close()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+
+		os.Exit(1)
+	}
+}
+
+// run carries out the command in args, the command line left over once the
+// global flags have been parsed.
+func (app *application) run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing command: %w", errUsage)
+	}
+
+	command, args := args[0]+" "+args[1], args[2:]
+
+	switch command {
+	case "users list":
+		return app.listUsers(ctx, args)
+	case "users create":
+		return app.createUser(ctx, args)
+	case "users deactivate":
+		return app.deactivateUser(ctx, args)
+	case "users role":
+		return app.assignRole(ctx, args)
+	case "tokens revoke":
+		return app.revokeTokens(ctx, args)
+	case "tokens purge":
+		return app.purgeTokens(ctx, args)
+	case "posts delete", "posts restore":
+		return app.moderatePost(ctx, args, command == "posts delete")
+	case "comments delete", "comments restore":
+		return app.moderateComment(ctx, args, command == "comments delete")
+	case "tags merge":
+		return app.mergeTags(ctx, args)
+	default:
+		return fmt.Errorf("unknown command %q: %w", command, errUsage)
+	}
+}
+
+func openDB(cfg config) (*sql.DB, error) {
+	if cfg.db.dsn == "" {
+		return nil, errors.New("no database given, set -db-dsn or BLOGLY_DB_DSN")
+	}
+
+	db, err := sql.Open("postgres", cfg.db.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+// newTestApplication returns an application on in-memory stores seeded with
+// users alice (1) and bob (2), each holding an authentication token, post 1
+// by alice tagged "golang" (1), comment 1 on it by bob, and a tag "go" (2).
+func newTestApplication(t *testing.T) (*application, *bytes.Buffer, map[string]string) {
+	t.Helper()
+
+	ctx := context.Background()
+	models := data.NewMemoryModels()
+	tokens := make(map[string]string)
+
+	for _, name := range []string{"alice", "bob"} {
+		user := &data.User{Username: name, Email: name + "@example.com", Activated: true}
+
+		err := user.Password.Set("pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = models.Users.Insert(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens[name] = token.Plaintext
+	}
+
+	err := models.Posts.Insert(ctx, &data.Post{UserID: 1, Title: "Hello world", Content: "The first post", Status: data.PostStatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Comments.Insert(ctx, &data.Comment{PostID: 1, UserID: 2, Content: "Nice post"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"golang", "go"} {
+		err := models.Tags.Insert(ctx, &data.Tag{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = models.PostTags.Insert(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+
+	return &application{models: models, out: out}, out, tokens
+}
+
+func TestCommands(t *testing.T) {
+	app, out, tokens := newTestApplication(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		args     string
+		stdin    string
+		json     bool
+		usage    bool
+		err      string
+		contains []string
+	}{
+		{name: "no command", args: "users", usage: true},
+		{name: "unknown command", args: "users frobnicate", usage: true},
+		{name: "list", args: "users list", contains: []string{"ID  USERNAME  EMAIL", "1   alice     alice@example.com  author  true"}},
+		{name: "list json", args: "users list", json: true, contains: []string{`"username": "bob"`}},
+
+		{name: "create", args: "users create -username carol -email carol@example.com -role moderator -activated", stdin: "pa55word1234\n", contains: []string{"3   carol     carol@example.com  moderator  true"}},
+		{name: "create duplicate", args: "users create -username carol2 -email carol@example.com", stdin: "pa55word1234", err: "email address already exists"},
+		{name: "create invalid", args: "users create -username dave -email nope -role owner", stdin: "short\r\n", err: "invalid input: email must be a valid email address; password must be at least 8 bytes long; role must be one of"},
+		{name: "create stray argument", args: "users create -username dave extra", usage: true},
+		{name: "create password on the command line", args: "users create -username dave -email dave@example.com -password pa55word1234", usage: true},
+
+		{name: "role", args: "users role 2 moderator", json: true, contains: []string{`"role": "moderator"`}},
+		{name: "role invalid", args: "users role 2 owner", err: "role must be one of"},
+		{name: "role missing user", args: "users role 99 reader", err: "user 99 not found"},
+		{name: "role bad id", args: "users role two reader", usage: true},
+		{name: "role admin", args: "users role 2 admin", json: true, contains: []string{`"role": "admin"`}},
+		{name: "role last admin", args: "users role 2 reader", err: "last active admin"},
+
+		{name: "deactivate", args: "users deactivate 1", json: true, contains: []string{`"activated": false`}},
+
+		{name: "revoke", args: "tokens revoke 2", contains: []string{"revoked every token of user 2"}},
+		{name: "revoke missing user", args: "tokens revoke 99", err: "user 99 not found"},
+
+		{name: "delete post", args: "posts delete 1", contains: []string{"post 1 deleted"}},
+		{name: "delete deleted post", args: "posts delete 1", err: "post 1 not found"},
+		{name: "restore post", args: "posts restore 1", json: true, contains: []string{`"status": "restored"`}},
+
+		{name: "delete comment", args: "comments delete 1 1", contains: []string{"comment 1 on post 1 deleted"}},
+		{name: "delete comment wrong post", args: "comments delete 2 1", err: "comment 1 not found on post 2"},
+		{name: "restore comment", args: "comments restore 1 1", contains: []string{"comment 1 on post 1 restored"}},
+
+		{name: "merge into itself", args: "tags merge 1 1", err: "merged into itself"},
+		{name: "merge missing tag", args: "tags merge 1 99", err: "tag 1 or 99 not found"},
+		{name: "merge", args: "tags merge 1 2", contains: []string{"2   go    1"}},
+		{name: "merge again", args: "tags merge 1 2", err: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			app.in = strings.NewReader(tt.stdin)
+			app.json = tt.json
+
+			err := app.run(ctx, strings.Fields(tt.args))
+
+			switch {
+			case tt.usage:
+				if !errors.Is(err, errUsage) {
+					t.Fatalf("got error %v; want a usage error", err)
+				}
+				return
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v; want %q", err, tt.err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if tt.json && !json.Valid(out.Bytes()) {
+				t.Errorf("got invalid JSON: %s", out)
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+
+	for name, token := range tokens {
+		_, err := app.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got %v for %s's token; want it revoked", err, name)
+		}
+	}
+
+	tags, _, err := app.models.Tags.GetAllForPost(ctx, 1, data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tags) != 1 || tags[0].Name != "go" {
+		t.Errorf("got tags %v on post 1; want only go", tags)
+	}
+}
+
+func TestPurgeTokens(t *testing.T) {
+	app, out, tokens := newTestApplication(t)
+	app.json = true
+
+	ctx := context.Background()
+
+	for range 3 {
+		_, err := app.models.Tokens.New(ctx, 1, -time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := app.run(ctx, []string{"tokens", "purge", "-batch-size", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), `"deleted": 3`) {
+		t.Errorf("got %s; want 3 deleted", out)
+	}
+
+	_, err = app.models.Users.GetForToken(ctx, data.ScopeAuthentication, tokens["alice"])
+	if err != nil {
+		t.Errorf("got %v for an unexpired token; want it kept", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+// table is how a command's result is shown to a person.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// write prints v as JSON when -json is set and t as an aligned table
+// otherwise.
+func (app *application) write(v any, t table) error {
+	if app.json {
+		return app.writeJSON(v)
+	}
+
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// writeMessage prints v as JSON when -json is set and the message otherwise.
+func (app *application) writeMessage(v any, format string, args ...any) error {
+	if app.json {
+		return app.writeJSON(v)
+	}
+
+	_, err := fmt.Fprintf(app.out, format+"\n", args...)
+	return err
+}
+
+func (app *application) writeJSON(v any) error {
+	js, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	_, err = app.out.Write(js)
+	return err
+}
+
+func userTable(users ...*data.User) table {
+	t := table{header: []string{"ID", "USERNAME", "EMAIL", "ROLE", "ACTIVATED", "CREATED"}}
+
+	for _, user := range users {
+		t.rows = append(t.rows, []string{
+			strconv.FormatInt(user.ID, 10),
+			user.Username,
+			user.Email,
+			user.Role,
+			strconv.FormatBool(user.Activated),
+			user.CreatedAt.UTC().Format(time.DateTime),
+		})
+	}
+
+	return t
+}
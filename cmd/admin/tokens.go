@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+func (app *application) revokeTokens(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("tokens revoke takes a user ID: %w", errUsage)
+	}
+
+	user, err := app.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeMessage(map[string]any{"user_id": user.ID, "revoked": true}, "revoked every token of user %d", user.ID)
+}
+
+// purgeTokens deletes expired tokens straight away, as the API's token
+// janitor would on its next run.
+func (app *application) purgeTokens(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tokens purge", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	batchSize := fs.Int("batch-size", 1000, "")
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 0 || *batchSize < 1 {
+		return fmt.Errorf("tokens purge: invalid arguments: %w", errUsage)
+	}
+
+	deleted, err := app.models.Tokens.DeleteExpired(ctx, *batchSize)
+	if err != nil {
+		return err
+	}
+
+	return app.writeMessage(map[string]any{"deleted": deleted}, "deleted %d expired tokens", deleted)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/validator"
+	"golang.org/x/term"
+)
+
+// tokenScopes are revoked together when a user's tokens are revoked, so that
+// a deactivated user can't log back in or reactivate the account either.
+var tokenScopes = []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset}
+
+func (app *application) listUsers(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("users list takes no arguments: %w", errUsage)
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     100,
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+		SkipCount:    true,
+	}
+
+	users := []*data.User{}
+
+	for {
+		page, metadata, err := app.models.Users.GetAll(ctx, filters)
+		if err != nil {
+			return err
+		}
+
+		users = append(users, page...)
+
+		if metadata.NextCursor == "" {
+			break
+		}
+
+		filters.After = metadata.NextCursor
+	}
+
+	return app.write(users, userTable(users...))
+}
+
+func (app *application) createUser(ctx context.Context, args []string) error {
+	var user data.User
+
+	fs := flag.NewFlagSet("users create", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	fs.StringVar(&user.Username, "username", "", "")
+	fs.StringVar(&user.Email, "email", "", "")
+	fs.StringVar(&user.Role, "role", data.RoleAuthor, "")
+	fs.BoolVar(&user.Activated, "activated", false, "")
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 0 {
+		return fmt.Errorf("users create: invalid arguments: %w", errUsage)
+	}
+
+	password, err := app.readPassword()
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	data.ValidateUser(v, &user)
+	data.ValidateRole(v, user.Role)
+
+	if !v.Valid() {
+		return validationError(v)
+	}
+
+	err = app.models.Users.Insert(ctx, &user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return errors.New("a user with this email address already exists")
+		case errors.Is(err, data.ErrDuplicateUsername):
+			return errors.New("a user with this username already exists")
+		default:
+			return err
+		}
+	}
+
+	return app.write(user, userTable(&user))
+}
+
+// readPassword reads a password from the first line of the input, so that it
+// doesn't end up in the shell history or the process list. When the input is
+// a terminal the password is prompted for, without echoing it, and has to be
+// typed twice.
+func (app *application) readPassword() (string, error) {
+	if f, ok := app.in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		password, err := promptPassword(f, "Password: ")
+		if err != nil {
+			return "", err
+		}
+
+		confirmation, err := promptPassword(f, "Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+
+		if password != confirmation {
+			return "", errors.New("passwords do not match")
+		}
+
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(app.in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func promptPassword(f *os.File, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	b, err := term.ReadPassword(int(f.Fd()))
+	return string(b), err
+}
+
+// deactivateUser stops a user from logging in: the account goes back to
+// unactivated and every token the user holds is revoked.
+func (app *application) deactivateUser(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("users deactivate takes a user ID: %w", errUsage)
+	}
+
+	user, err := app.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	user.Activated = false
+
+	err = app.models.Users.Update(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.write(user, userTable(user))
+}
+
+func (app *application) assignRole(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("users role takes a user ID and a role: %w", errUsage)
+	}
+
+	user, err := app.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	user.Role = args[1]
+
+	v := validator.New()
+
+	if data.ValidateRole(v, user.Role); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = app.models.Users.Update(ctx, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			return errors.New("the last active admin can't be given another role")
+		default:
+			return err
+		}
+	}
+
+	return app.write(user, userTable(user))
+}
+
+func (app *application) getUser(ctx context.Context, arg string) (*data.User, error) {
+	id, err := parseID(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.models.Users.GetByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("user %d not found", id)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (app *application) deleteTokens(ctx context.Context, userID int64) error {
+	for _, scope := range tokenScopes {
+		err := app.models.Tokens.DeleteAllForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseID(arg string) (int64, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid ID %q: %w", arg, errUsage)
+	}
+
+	return id, nil
+}
+
+// validationError lists the validator's errors, one field after another.
+func validationError(v *validator.Validator) error {
+	var problems []string
+
+	for key, message := range v.Errors {
+		problems = append(problems, key+" "+message)
+	}
+
+	sort.Strings(problems)
+
+	return errors.New("invalid input: " + strings.Join(problems, "; "))
+}
@@ -16,29 +16,59 @@ func (app *application) listPostCommentsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if !app.canViewComments(w, r, postID) {
+		return
+	}
+
 	var input struct {
+		Format string
 		data.Filters
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
+	input.Format = app.readString(qs, "format", "flat")
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "created_at", "user_id", "-id", "-created_at", "-user_id"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
+
+	v.Check(validator.PermittedValue(input.Format, "flat", "tree"), "format", "must be flat or tree")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	comments, metadata, err := app.models.Comments.GetAllForPost(postID, input.Filters)
+	// In tree format the page is made of top-level comments, each carrying
+	// all of its replies.
+	tree := input.Format == "tree"
+
+	comments, metadata, err := app.models.Comments.GetAllForPost(r.Context(), postID, tree, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if tree && len(comments) > 0 {
+		rootIDs := make([]int64, len(comments))
+		for i, comment := range comments {
+			rootIDs[i] = comment.ID
+		}
+
+		replies, err := app.models.Comments.GetReplies(r.Context(), rootIDs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		comments = data.NestComments(comments, replies)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -58,7 +88,11 @@ func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	comment, err := app.models.Comments.Get(postID, commentID)
+	if !app.canViewComments(w, r, postID) {
+		return
+	}
+
+	comment, err := app.models.Comments.Get(r.Context(), postID, commentID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -78,7 +112,8 @@ func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Content string `json:"content"`
+		Content         string `json:"content"`
+		ParentCommentID *int64 `json:"parent_comment_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -93,22 +128,45 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !app.canViewComments(w, r, postID) {
+		return
+	}
+
 	currentUser := app.contextGetUser(r)
 
 	comment := &data.Comment{
-		PostID:  postID,
-		UserID:  currentUser.ID,
-		Content: input.Content,
+		PostID:          postID,
+		UserID:          currentUser.ID,
+		ParentCommentID: input.ParentCommentID,
+		Content:         input.Content,
 	}
 
 	v := validator.New()
 
+	if input.ParentCommentID != nil {
+		parent, err := app.models.Comments.Get(r.Context(), postID, *input.ParentCommentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("parent_comment_id", "must be a comment on the same post")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		v.Check(!parent.Deleted, "parent_comment_id", "must not be a deleted comment")
+
+		comment.Depth = parent.Depth + 1
+	}
+
 	if data.ValidateComment(v, comment); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Comments.Insert(comment)
+	err = app.models.Comments.Insert(r.Context(), comment)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -141,7 +199,11 @@ func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	comment, err := app.models.Comments.Get(postID, commentID)
+	if !app.canViewComments(w, r, postID) {
+		return
+	}
+
+	comment, err := app.models.Comments.Get(r.Context(), postID, commentID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -152,10 +214,15 @@ func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if comment.Deleted {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	currentUser := app.contextGetUser(r)
 
-	if currentUser.ID != comment.UserID {
-		app.invalidUserResponse(w, r)
+	if currentUser.ID != comment.UserID && !currentUser.Can(data.PermissionCommentsModerate) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
@@ -180,7 +247,7 @@ func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Comments.Update(comment)
+	err = app.models.Comments.Update(r.Context(), comment)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -212,13 +279,13 @@ func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Requ
 
 	currentUser := app.contextGetUser(r)
 
-	err = app.models.Comments.Delete(commentID, currentUser.ID, postID)
+	err = app.models.Comments.Delete(r.Context(), commentID, currentUser.ID, postID, currentUser.Can(data.PermissionCommentsModerate))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrUnauthorized):
-			app.invalidUserResponse(w, r)
+			app.notPermittedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -250,13 +317,16 @@ func (app *application) listUserCommentsHandler(w http.ResponseWriter, r *http.R
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "created_at")
 	input.Filters.SortSafelist = []string{"created_at", "updated_at", "-created_at", "-updated_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	comments, metadata, err := app.models.Comments.GetAllByUser(userID, input.Filters)
+	comments, metadata, err := app.models.Comments.GetAllByUser(r.Context(), userID, app.contextGetUser(r).ID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -267,3 +337,74 @@ func (app *application) listUserCommentsHandler(w http.ResponseWriter, r *http.R
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	commentID, err := app.readIDParam(r, "comment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canViewComments(w, r, postID) {
+		return
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	err = app.models.Comments.Restore(r.Context(), commentID, currentUser.ID, postID, currentUser.Can(data.PermissionCommentsModerate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnauthorized):
+			app.notPermittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	comment, err := app.models.Comments.Get(r.Context(), postID, commentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// canViewComments checks that the current user may see the post, and so its
+// comments, writing a not found response and returning false if not.
+func (app *application) canViewComments(w http.ResponseWriter, r *http.Request, postID int64) bool {
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	if !canViewPost(app.contextGetUser(r), post) {
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/manuelam2003/blogly/internal/data"
+	"gopkg.in/yaml.v3"
+)
+
+// Every setting is a flag. The same setting can be given in a configuration
+// file, under the flag's name, or in an environment variable named after the
+// flag with a BLOGLY_ prefix: -db-max-open-conns is db-max-open-conns (or
+// db_max_open_conns, or max_open_conns under db) in the file and
+// BLOGLY_DB_MAX_OPEN_CONNS in the environment. Flags take precedence over
+// the environment, which takes precedence over the file, which takes
+// precedence over the defaults.
+
+// registerFlags defines the flags for every field of cfg, setting each field
+// to its default.
+func registerFlags(fs *flag.FlagSet, cfg *config) {
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	fs.StringVar(&cfg.log.format, "log-format", "text", "Log format (text|json)")
+	fs.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "PostgreSQL per-query timeout")
+
+	fs.BoolVar(&cfg.migrate.onStart, "migrate-on-start", false, "Apply pending database migrations before starting the server")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 0.1, "Rate limiter maximum login attempts per second")
+	fs.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 5, "Rate limiter maximum login attempt burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", false, "Enable rate limiter")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Where rate limit buckets are kept (memory|postgres)")
+
+	fs.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed logins to an account before it is locked out")
+	fs.IntVar(&cfg.login.ipMaxFailures, "login-ip-max-failures", 50, "Failed logins from an IP address before it is locked out")
+	fs.DurationVar(&cfg.login.backoff, "login-backoff", time.Second, "Delay once half the allowed failed logins are used, doubled on each further failure")
+	fs.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "How long logins stay locked out")
+
+	fs.Var((*prefixesValue)(&cfg.proxies.trusted), "trusted-proxies", "Trusted reverse proxy addresses and CIDR ranges (space separated)")
+
+	fs.Var((*fieldsValue)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", 10*time.Minute, "How long browsers may cache CORS preflight responses")
+	fs.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow credentialed CORS requests from trusted origins")
+
+	fs.DurationVar(&cfg.tokens.accessTTL, "tokens-access-ttl", 15*time.Minute, "Authentication token lifetime")
+	fs.DurationVar(&cfg.tokens.refreshTTL, "tokens-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	fs.DurationVar(&cfg.tokens.cleanupInterval, "tokens-cleanup-interval", time.Hour, "How often expired tokens are deleted")
+	fs.IntVar(&cfg.tokens.cleanupBatchSize, "tokens-cleanup-batch-size", 1000, "How many expired tokens are deleted per statement")
+
+	fs.StringVar(&cfg.pagination.cursorSecret, "cursor-secret", "", "Key for signing pagination cursors, shared by every instance (required outside development, where it is random per process when empty)")
+
+	fs.DurationVar(&cfg.publisher.interval, "publisher-interval", time.Minute, "How often scheduled posts are checked for publishing")
+
+	fs.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted items are kept before being purged")
+	fs.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often expired trash is purged")
+
+	fs.StringVar(&cfg.metrics.addr, "metrics-addr", "localhost:4001", "Address of the admin listener serving /metrics and the health probes (disabled when empty)")
+
+	fs.DurationVar(&cfg.healthcheck.timeout, "healthcheck-timeout", 2*time.Second, "How long the readiness check waits for the database")
+	fs.DurationVar(&cfg.shutdown.drainDelay, "shutdown-drain-delay", 5*time.Second, "How long to keep serving after failing readiness checks on shutdown, so load balancers can stop sending requests")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host (emails are only logged when empty)")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Blogly <no-reply@blogly.local>", "SMTP sender")
+}
+
+// commandLineOnly are the flags that control how the configuration is
+// loaded, rather than being part of it.
+var commandLineOnly = []string{"config", "print-config"}
+
+// secretSettings are redacted when the configuration is printed.
+var secretSettings = []string{"db-dsn", "cursor-secret", "smtp-password"}
+
+// loadConfig applies the configuration layers to the flags registered on fs:
+// the configuration file named by the -config flag or BLOGLY_CONFIG, then
+// the environment, then the flags in args.
+func loadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	// The flags are parsed once to find the configuration file, and again
+	// at the end so that they override it.
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	path, _ := lookupEnv("BLOGLY_CONFIG")
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		path = f.Value.String()
+	}
+
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+
+		for _, name := range sortedKeys(settings) {
+			err := setConfig(fs, name, settings[name])
+			if err != nil {
+				return fmt.Errorf("config file %s: %w", path, err)
+			}
+		}
+	}
+
+	var envErr error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || slices.Contains(commandLineOnly, f.Name) {
+			return
+		}
+
+		if val, ok := lookupEnv(envName(f.Name)); ok {
+			err := f.Value.Set(val)
+			if err != nil {
+				envErr = fmt.Errorf("environment variable %s: invalid value %q: %w", envName(f.Name), val, err)
+			}
+		}
+	})
+
+	if envErr != nil {
+		return envErr
+	}
+
+	return fs.Parse(args)
+}
+
+func envName(flagName string) string {
+	return "BLOGLY_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func setConfig(fs *flag.FlagSet, name, val string) error {
+	if fs.Lookup(name) == nil || slices.Contains(commandLineOnly, name) {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+
+	err := fs.Set(name, val)
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q: %w", name, val, err)
+	}
+
+	return nil
+}
+
+// readConfigFile reads a YAML, TOML or JSON file, picked by its extension,
+// into settings keyed by flag name.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := map[string]any{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &tree)
+	case ".toml":
+		err = toml.Unmarshal(b, &tree)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&tree)
+		if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+			err = errors.New("unexpected data after the top-level object")
+		}
+	default:
+		return nil, errors.New("unknown format, the name must end in .yaml, .yml, .toml or .json")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+
+	err = flattenConfig(settings, "", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// flattenConfig turns nested tables into flag names, so that max_open_conns
+// under db is the setting db-max-open-conns. Lists become space separated
+// values.
+func flattenConfig(settings map[string]string, prefix string, tree map[string]any) error {
+	for key, val := range tree {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		if table, ok := val.(map[string]any); ok {
+			err := flattenConfig(settings, name, table)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, err := configString(val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if _, ok := settings[name]; ok {
+			return fmt.Errorf("%s is set more than once", name)
+		}
+
+		settings[name] = s
+	}
+
+	return nil
+}
+
+func configString(val any) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case []any:
+		fields := make([]string, len(v))
+
+		for i, item := range v {
+			s, err := configString(item)
+			if err != nil {
+				return "", err
+			}
+			fields[i] = s
+		}
+
+		return strings.Join(fields, " "), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", val)
+	}
+}
+
+// validate checks the configuration as a whole, reporting every problem it
+// finds. Settings only the server uses are checked when serve is true, so
+// that the migrate subcommand runs with just the logging and database ones.
+func (cfg config) validate(serve bool) error {
+	var errs []error
+
+	check := func(ok bool, name, message string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("-%s %s", name, message))
+		}
+	}
+
+	check(slices.Contains([]string{"development", "staging", "production"}, cfg.env), "env", "must be development, staging or production")
+
+	check(slices.Contains([]string{"text", "json"}, cfg.log.format), "log-format", "must be text or json")
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(cfg.log.level)), "log-level", "must be debug, info, warn or error")
+
+	check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	check(cfg.db.maxIdleTime >= 0, "db-max-idle-time", "must not be negative")
+	check(cfg.db.queryTimeout > 0, "db-query-timeout", "must be greater than zero")
+
+	if !serve {
+		return errors.Join(errs...)
+	}
+
+	check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+
+	check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	check(cfg.limiter.authRPS > 0, "limiter-auth-rps", "must be greater than zero")
+	check(cfg.limiter.authBurst > 0, "limiter-auth-burst", "must be greater than zero")
+	check(slices.Contains([]string{"memory", "postgres"}, cfg.limiter.store), "limiter-store", "must be memory or postgres")
+
+	check(cfg.login.maxFailures > 0, "login-max-failures", "must be greater than zero")
+	check(cfg.login.ipMaxFailures > 0, "login-ip-max-failures", "must be greater than zero")
+	check(cfg.login.backoff >= 0, "login-backoff", "must not be negative")
+	check(cfg.login.lockout > 0, "login-lockout", "must be greater than zero")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "cors-trusted-origins", fmt.Sprintf("must be origins such as https://example.com, not %q", origin))
+	}
+	check(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+
+	check(cfg.tokens.accessTTL > 0, "tokens-access-ttl", "must be greater than zero")
+	check(cfg.tokens.refreshTTL > 0, "tokens-refresh-ttl", "must be greater than zero")
+	check(cfg.tokens.cleanupInterval > 0, "tokens-cleanup-interval", "must be greater than zero")
+	check(cfg.tokens.cleanupBatchSize > 0, "tokens-cleanup-batch-size", "must be greater than zero")
+
+	// A random key would make cursors fail on other instances and after
+	// every restart.
+	check(cfg.env == "development" || cfg.pagination.cursorSecret != "", "cursor-secret", "must be provided outside development")
+
+	check(cfg.publisher.interval > 0, "publisher-interval", "must be greater than zero")
+
+	check(cfg.trash.retention > 0, "trash-retention", "must be greater than zero")
+	check(cfg.trash.purgeInterval > 0, "trash-purge-interval", "must be greater than zero")
+
+	check(cfg.healthcheck.timeout > 0, "healthcheck-timeout", "must be greater than zero")
+	check(cfg.shutdown.drainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+
+	check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	check(cfg.smtp.host == "" || cfg.smtp.sender != "", "smtp-sender", "must be provided when -smtp-host is set")
+
+	return errors.Join(errs...)
+}
+
+// printConfig writes the settings in fs as a YAML configuration file, with
+// secrets redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	settings := map[string]any{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(commandLineOnly, f.Name) {
+			return
+		}
+
+		var val any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			val = getter.Get()
+		}
+
+		if d, ok := val.(time.Duration); ok {
+			val = d.String()
+		}
+
+		if s, ok := val.(string); ok && s != "" && slices.Contains(secretSettings, f.Name) {
+			val = redact(f.Name, s)
+		}
+
+		settings[f.Name] = val
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err := enc.Encode(settings)
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+var dsnPasswordRX = regexp.MustCompile(`password=('(\\.|[^'])*'|\S+)`)
+
+// redact hides a secret. Only the password is hidden in a DSN, since the
+// rest of it is what one looks at when the database can't be reached.
+func redact(name, val string) string {
+	if name != "db-dsn" {
+		return "REDACTED"
+	}
+
+	if u, err := url.Parse(val); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	return dsnPasswordRX.ReplaceAllString(val, "password=xxxxx")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// fieldsValue is a flag holding a space separated list.
+type fieldsValue []string
+
+func (v *fieldsValue) String() string {
+	return strings.Join(*v, " ")
+}
+
+func (v *fieldsValue) Set(val string) error {
+	*v = strings.Fields(val)
+	return nil
+}
+
+func (v *fieldsValue) Get() any {
+	return []string(*v)
+}
+
+// prefixesValue is a flag holding a space separated list of IP addresses
+// and CIDR ranges.
+type prefixesValue []netip.Prefix
+
+func (v *prefixesValue) String() string {
+	return strings.Join(v.strings(), " ")
+}
+
+func (v *prefixesValue) Set(val string) error {
+	prefixes, err := parseTrustedProxies(val)
+	if err != nil {
+		return err
+	}
+
+	*v = prefixes
+	return nil
+}
+
+func (v *prefixesValue) Get() any {
+	return v.strings()
+}
+
+func (v *prefixesValue) strings() []string {
+	s := make([]string, len(*v))
+	for i, prefix := range *v {
+		s[i] = prefix.String()
+	}
+
+	return s
+}
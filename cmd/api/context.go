@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
 	"github.com/manuelam2003/blogly/internal/data"
@@ -9,7 +10,13 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey         = contextKey("user")
+	tokenContextKey        = contextKey("token")
+	invalidTokenContextKey = contextKey("invalid_token")
+	routeContextKey        = contextKey("route")
+	loggerContextKey       = contextKey("logger")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +31,71 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+
+	return token
+}
+
+// contextSetInvalidToken marks the request as carrying an authentication
+// token that didn't check out.
+func (app *application) contextSetInvalidToken(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), invalidTokenContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextHasInvalidToken(r *http.Request) bool {
+	invalid, _ := r.Context().Value(invalidTokenContextKey).(bool)
+	return invalid
+}
+
+// contextSetRoute records the pattern of the route handling the request. The
+// pattern is kept behind a pointer, so when a middleware has already called
+// contextSetRoute it sees the pattern set later by the router, once the
+// handler has returned.
+func (app *application) contextSetRoute(r *http.Request, route string) *http.Request {
+	if p, ok := r.Context().Value(routeContextKey).(*string); ok {
+		*p = route
+		return r
+	}
+
+	ctx := context.WithValue(r.Context(), routeContextKey, &route)
+	return r.WithContext(ctx)
+}
+
+// contextGetRoute returns the pattern of the route handling the request, or
+// an empty string if the request didn't match one.
+func (app *application) contextGetRoute(r *http.Request) string {
+	p, ok := r.Context().Value(routeContextKey).(*string)
+	if !ok {
+		return ""
+	}
+
+	return *p
+}
+
+func (app *application) contextSetLogger(r *http.Request, logger *slog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// contextGetLogger returns the logger for the request, which carries its
+// request ID, or the application's logger if the request hasn't been given
+// one.
+func (app *application) contextGetLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*slog.Logger)
+	if !ok {
+		return app.logger
+	}
+
+	return logger
+}
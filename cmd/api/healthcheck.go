@@ -1,20 +1,179 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
+	"runtime"
+	"runtime/debug"
 )
 
+// buildCommit and buildTime are set at link time, for example with
+//
+//	go build -ldflags="-X main.buildCommit=$(git describe --always --dirty) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// When they aren't, the VCS details the go command stamps into the binary
+// are used instead.
+var (
+	buildCommit string
+	buildTime   string
+)
+
+type buildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	GoVersion string
+}
+
+var build = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    buildCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+
+	return info
+}
+
+// The readiness check only needs these parts of *sql.DB and
+// *migrate.Migrator, so the tests can stand in for them.
+type pinger interface {
+	PingContext(ctx context.Context) error
+	Stats() sql.DBStats
+}
+
+type schemaVersioner interface {
+	Version(ctx context.Context) (int64, bool, error)
+	Latest() int64
+}
+
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"status":      "available",
+		"system_info": app.systemInfo(),
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// livenessHandler reports that the process is up and serving requests. It
+// doesn't look at the database, so that an outage there doesn't get every
+// instance restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler reports whether the instance should be sent traffic: the
+// database answers within the healthcheck timeout, its schema is usable, and
+// the server isn't shutting down.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		env := envelope{"status": "unavailable", "reason": "shutting down"}
+
+		err := app.writeJSON(w, http.StatusServiceUnavailable, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.healthcheck.timeout)
+	defer cancel()
+
+	ready := true
+
+	database := map[string]any{"status": "up"}
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logError(r, err)
+		database["status"] = "down"
+		ready = false
+	}
+
+	stats := app.db.Stats()
+	database["pool"] = map[string]any{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration":        stats.WaitDuration.String(),
+	}
+
+	migrations := map[string]any{"status": "up", "latest": app.schema.Latest()}
+
+	version, dirty, err := app.schema.Version(ctx)
+	if err != nil {
+		app.logError(r, err)
+		migrations["status"] = "down"
+		ready = false
+	} else {
+		migrations["version"] = version
+		migrations["dirty"] = dirty
+
+		if dirty || version > app.schema.Latest() {
+			migrations["status"] = "down"
+			ready = false
+		}
+	}
+
 	env := envelope{
 		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
+		"checks": map[string]any{
+			"database":   database,
+			"migrations": migrations,
 		},
+		"system_info": app.systemInfo(),
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	status := http.StatusOK
+	if !ready {
+		env["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     build.Version,
+		"commit":      build.Commit,
+		"build_time":  build.BuildTime,
+		"go_version":  build.GoVersion,
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/validator"
+)
+
+// loginKey identifies whose failed logins are counted: the account being
+// logged into, by email address so that unknown addresses are treated the
+// same as known ones, or the IP address the attempts come from.
+type loginKey struct {
+	key         string
+	maxFailures int
+	account     bool
+}
+
+func (app *application) loginKeys(r *http.Request, email string) []loginKey {
+	return []loginKey{
+		{key: accountLoginKey(email), maxFailures: app.config.login.maxFailures, account: true},
+		{key: "ip:" + app.clientIP(r), maxFailures: app.config.login.ipMaxFailures},
+	}
+}
+
+func accountLoginKey(email string) string {
+	return "email:" + email
+}
+
+// reserveLogin counts the login attempt as a failure against each key before
+// the password is checked, so that concurrent attempts can't all get past the
+// backoff. When a key turns the attempt away, the failures counted against
+// the other keys are given back, and it returns nil with how long the client
+// has to wait.
+func (app *application) reserveLogin(ctx context.Context, keys []loginKey) ([]*data.LoginFailures, time.Duration, error) {
+	attempts := make([]*data.LoginFailures, len(keys))
+	refused := false
+
+	var wait time.Duration
+
+	for i, k := range keys {
+		failures, reserved, err := app.models.LoginFailures.Reserve(ctx, k.key, k.maxFailures, app.config.login.backoff, app.config.login.lockout)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !reserved {
+			refused = true
+			wait = max(wait, failures.Wait(k.maxFailures, app.config.login.backoff, app.config.login.lockout, time.Now()))
+			continue
+		}
+
+		attempts[i] = failures
+	}
+
+	if !refused {
+		return attempts, 0, nil
+	}
+
+	for i, k := range keys {
+		if attempts[i] == nil {
+			continue
+		}
+
+		err := app.models.LoginFailures.Release(ctx, k.key, k.maxFailures)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return nil, wait, nil
+}
+
+// loginSucceeded forgets the failures for the account and gives back the
+// failure counted against the other keys for this attempt.
+func (app *application) loginSucceeded(ctx context.Context, keys []loginKey) error {
+	for _, k := range keys {
+		var err error
+
+		if k.account {
+			err = app.models.LoginFailures.Delete(ctx, k.key)
+		} else {
+			err = app.models.LoginFailures.Release(ctx, k.key, k.maxFailures)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reportLoginLockouts reports the keys that the failed attempt locked out: the
+// lockout is written to the audit log and, for an account, the user is sent
+// an email about it. attempts are the failures reserveLogin counted, and user
+// is nil when no account has the email address that was tried.
+func (app *application) reportLoginLockouts(r *http.Request, keys []loginKey, attempts []*data.LoginFailures, user *data.User) error {
+	for i, k := range keys {
+		failures := attempts[i]
+
+		// Only the failure that reached the limit reports the lockout.
+		if failures.Count != k.maxFailures {
+			continue
+		}
+
+		entry := &data.AuditEntry{
+			Action:  data.AuditLoginLocked,
+			Subject: k.key,
+			IP:      app.clientIP(r),
+		}
+
+		account := k.account && user != nil
+		if account {
+			entry.UserID = &user.ID
+		}
+
+		err := app.models.Audit.Insert(r.Context(), entry)
+		if err != nil {
+			return err
+		}
+
+		app.contextGetLogger(r).Warn("logins locked out after repeated failures", "subject", k.key, "until", failures.LockedUntil)
+
+		if account {
+			app.sendLockoutEmail(r, user, *failures.LockedUntil)
+		}
+	}
+
+	return nil
+}
+
+// failedLoginResponse reports any lockout the failed login caused before
+// sending the usual invalid credentials response. The failure itself was
+// already counted by reserveLogin.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, keys []loginKey, attempts []*data.LoginFailures, user *data.User) {
+	err := app.reportLoginLockouts(r, keys, attempts, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+func (app *application) sendLockoutEmail(r *http.Request, user *data.User, lockedUntil time.Time) {
+	logger := app.contextGetLogger(r)
+	ip := app.clientIP(r)
+
+	app.background(func() {
+		data := map[string]any{
+			"username":    user.Username,
+			"ip":          ip,
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(user.Email, "login_locked.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+}
+
+// startLoginFailureCleanup regularly removes the failed logins that no longer
+// count towards a lockout, until ctx is cancelled.
+func (app *application) startLoginFailureCleanup(ctx context.Context) {
+	app.runPeriodically(ctx, time.Hour, func(ctx context.Context) {
+		deleted, err := app.models.LoginFailures.DeleteStale(ctx, time.Now().Add(-app.config.login.lockout))
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		if deleted > 0 {
+			app.logger.Info("removed stale login failures", "count", deleted)
+		}
+	})
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key := accountLoginKey(user.Email)
+
+	err = app.models.LoginFailures.Delete(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	err = app.models.Audit.Insert(r.Context(), &data.AuditEntry{
+		Action:  data.AuditLoginUnlocked,
+		UserID:  &user.ID,
+		ActorID: &admin.ID,
+		Subject: key,
+		IP:      app.clientIP(r),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserAuditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAllForUser(r.Context(), id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
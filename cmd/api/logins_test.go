@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const msgLoginThrottled = "too many failed login attempts, please try again later"
+
+func TestLoginLockout(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.login.maxFailures = 4
+	ts.app.config.login.backoff = time.Nanosecond
+
+	const (
+		wrong   = `{"email":"alice@example.com","password":"wrongpa55word"}`
+		right   = `{"email":"alice@example.com","password":"pa55word1234"}`
+		unknown = `{"email":"nobody@example.com","password":"wrongpa55word"}`
+	)
+
+	ts.run(t, []routeTest{
+		{name: "failure 1", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "failure 2", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "failure 3", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "failure 4 locks the account", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "right password while locked", method: http.MethodPost, path: "/v1/tokens/authentication", body: right, status: http.StatusTooManyRequests, contains: []string{msgLoginThrottled}},
+		{name: "other accounts are unaffected", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"bob@example.com","password":"pa55word1234"}`, status: http.StatusCreated},
+
+		{name: "unknown email 1", method: http.MethodPost, path: "/v1/tokens/authentication", body: unknown, status: http.StatusUnauthorized},
+		{name: "unknown email 2", method: http.MethodPost, path: "/v1/tokens/authentication", body: unknown, status: http.StatusUnauthorized},
+		{name: "unknown email 3", method: http.MethodPost, path: "/v1/tokens/authentication", body: unknown, status: http.StatusUnauthorized},
+		{name: "unknown email 4", method: http.MethodPost, path: "/v1/tokens/authentication", body: unknown, status: http.StatusUnauthorized},
+		{name: "unknown email is locked like an account", method: http.MethodPost, path: "/v1/tokens/authentication", body: unknown, status: http.StatusTooManyRequests, contains: []string{msgLoginThrottled}},
+
+		{name: "unlock anonymous", method: http.MethodPost, path: "/v1/users/1/unlock", status: http.StatusUnauthorized},
+		{name: "unlock not permitted", method: http.MethodPost, path: "/v1/users/1/unlock", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "unlock missing user", method: http.MethodPost, path: "/v1/users/99/unlock", user: "admin", status: http.StatusNotFound},
+		{name: "unlock", method: http.MethodPost, path: "/v1/users/1/unlock", user: "admin", status: http.StatusOK, contains: []string{"user account successfully unlocked"}},
+		{name: "right password after unlock", method: http.MethodPost, path: "/v1/tokens/authentication", body: right, status: http.StatusCreated},
+
+		{name: "audit not permitted", method: http.MethodGet, path: "/v1/users/1/audit", user: "alice", status: http.StatusForbidden},
+		{name: "audit", method: http.MethodGet, path: "/v1/users/1/audit", user: "admin", status: http.StatusOK, contains: []string{`"action": "login.unlocked"`, `"actor_id": 4`, `"action": "login.locked"`, `"subject": "email:alice@example.com"`, `"total_records": 2`}},
+		{name: "audit of other user", method: http.MethodGet, path: "/v1/users/2/audit", user: "admin", status: http.StatusOK, contains: []string{`"audit": []`}},
+	})
+
+	ts.app.wg.Wait()
+
+	var sent int
+	for _, message := range ts.mailer.Messages() {
+		if message.Recipient == "alice@example.com" && strings.Contains(message.Subject, "locked") {
+			sent++
+		}
+	}
+
+	if sent != 1 {
+		t.Errorf("got %d lockout emails to alice; want 1", sent)
+	}
+}
+
+func TestLoginLockoutByIP(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.login.ipMaxFailures = 3
+	ts.app.config.login.backoff = time.Nanosecond
+
+	for _, email := range []string{"alice@example.com", "bob@example.com", "nobody@example.com"} {
+		status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", `{"email":"`+email+`","password":"wrongpa55word"}`)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("got status %d; want 401; body: %s", status, body)
+		}
+	}
+
+	status, headers, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", `{"email":"reader@example.com","password":"pa55word1234"}`)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("got status %d; want 429; body: %s", status, body)
+	}
+
+	if headers.Get("Retry-After") != "900" {
+		t.Errorf("got Retry-After %q; want 900", headers.Get("Retry-After"))
+	}
+}
+
+// A successful login gives back the failure counted against the IP address
+// while its password was checked.
+func TestLoginSuccessByIP(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.login.ipMaxFailures = 3
+	ts.app.config.login.backoff = time.Nanosecond
+
+	const wrong = `{"email":"bob@example.com","password":"wrongpa55word"}`
+
+	ts.run(t, []routeTest{
+		{name: "failure 1", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "failure 2", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "right password", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"alice@example.com","password":"pa55word1234"}`, status: http.StatusCreated},
+		{name: "failure 3 locks the address", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusUnauthorized},
+		{name: "locked", method: http.MethodPost, path: "/v1/tokens/authentication", body: wrong, status: http.StatusTooManyRequests, contains: []string{msgLoginThrottled}},
+	})
+}
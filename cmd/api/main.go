@@ -3,13 +3,22 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/mailer"
+	"github.com/manuelam2003/blogly/internal/migrate"
+	"github.com/manuelam2003/blogly/migrations"
 )
 
 const version = "1.0.0"
@@ -22,38 +31,148 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		queryTimeout time.Duration
+	}
+	migrate struct {
+		onStart bool
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps       float64
+		burst     int
+		authRPS   float64
+		authBurst int
+		enabled   bool
+		store     string
+	}
+	login struct {
+		maxFailures   int
+		ipMaxFailures int
+		backoff       time.Duration
+		lockout       time.Duration
+	}
+	proxies struct {
+		trusted []netip.Prefix
+	}
+	cors struct {
+		trustedOrigins   []string
+		maxAge           time.Duration
+		allowCredentials bool
+	}
+	tokens struct {
+		accessTTL        time.Duration
+		refreshTTL       time.Duration
+		cleanupInterval  time.Duration
+		cleanupBatchSize int
+	}
+	pagination struct {
+		cursorSecret string
+	}
+	publisher struct {
+		interval time.Duration
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	metrics struct {
+		addr string
+	}
+	healthcheck struct {
+		timeout time.Duration
+	}
+	shutdown struct {
+		drainDelay time.Duration
+	}
+	log struct {
+		format string
+		level  string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config       config
+	logger       *slog.Logger
+	db           pinger
+	schema       schemaVersioner
+	models       data.Models
+	mailer       mailer.Mailer
+	metrics      *appMetrics
+	rateLimits   data.RateLimitStore
+	limiter      rateLimiter
+	authLimiter  rateLimiter
+	shuttingDown atomic.Bool
+	wg           sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	registerFlags(flag.CommandLine, &cfg)
+
+	flag.String("config", "", "Configuration file (.yaml, .yml, .toml or .json), read from BLOGLY_CONFIG when not set")
+	showConfig := flag.Bool("print-config", false, "Print the configuration, with secrets redacted, and exit")
+
+	err := loadConfig(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("BLOGLY_DB_DSN"), "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	if *showConfig {
+		err := printConfig(os.Stdout, flag.CommandLine)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", false, "Enable rate limiter")
+	err = cfg.validate(len(flag.Args()) == 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n  %s\n", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+		os.Exit(2)
+	}
 
-	flag.Parse()
+	if *showConfig {
+		return
+	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n", args[0], migrateUsage)
+			os.Exit(2)
+		}
+
+		err = runMigrate(cfg, logger, os.Stdout, args[1:])
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if cfg.pagination.cursorSecret != "" {
+		data.SetCursorSecret(cfg.pagination.cursorSecret)
+	} else {
+		logger.Warn("no -cursor-secret set, pagination cursors will only work on this instance until it restarts")
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -65,10 +184,37 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	models := data.NewModels(db, cfg.db.queryTimeout)
+
+	rateLimits, err := newRateLimitStore(cfg, models)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:      cfg,
+		logger:      logger,
+		db:          db,
+		schema:      migrator,
+		models:      models,
+		mailer:      newMailer(cfg, logger),
+		metrics:     newMetrics(db),
+		rateLimits:  rateLimits,
+		limiter:     newRateLimiter("api:", cfg.limiter.rps, cfg.limiter.burst),
+		authLimiter: newRateLimiter("auth:", cfg.limiter.authRPS, cfg.limiter.authBurst),
+	}
+
+	err = app.prepareSchema(migrator)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
 	err = app.serve()
@@ -78,6 +224,49 @@ func main() {
 	}
 }
 
+func newLogger(cfg config) (*slog.Logger, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(cfg.log.level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q: must be debug, info, warn or error", cfg.log.level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.log.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q: must be text or json", cfg.log.format)
+	}
+}
+
+// newRateLimitStore returns the store selected by -limiter-store. The memory
+// store limits each instance on its own; the Postgres store shares the limits
+// between every instance using the same database.
+func newRateLimitStore(cfg config, models data.Models) (data.RateLimitStore, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return data.NewMemoryRateLimitStore(), nil
+	case "postgres":
+		return models.RateLimits, nil
+	default:
+		return nil, fmt.Errorf("invalid -limiter-store %q: must be memory or postgres", cfg.limiter.store)
+	}
+}
+
+func newMailer(cfg config, logger *slog.Logger) mailer.Mailer {
+	if cfg.smtp.host == "" {
+		logger.Info("no smtp host configured, emails will be logged instead of sent")
+		return mailer.NewLog(logger)
+	}
+
+	return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
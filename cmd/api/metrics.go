@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/manuelam2003/blogly/internal/metrics"
+)
+
+type appMetrics struct {
+	registry    *metrics.Registry
+	requests    *metrics.CounterVec
+	duration    *metrics.HistogramVec
+	inFlight    *metrics.Gauge
+	rateLimited *metrics.Counter
+}
+
+// newMetrics registers the application's metrics. The connection pool stats
+// are read from db at scrape time, and are left out when db is nil.
+func newMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:    registry,
+		requests:    registry.NewCounterVec("blogly_http_requests_total", "HTTP requests by method, route and status code.", "method", "route", "status"),
+		duration:    registry.NewHistogramVec("blogly_http_request_duration_seconds", "HTTP request latencies by method and route.", metrics.DefBuckets, "method", "route"),
+		inFlight:    registry.NewGauge("blogly_http_requests_in_flight", "HTTP requests currently being served."),
+		rateLimited: registry.NewCounter("blogly_rate_limit_rejections_total", "Requests rejected by the rate limiter."),
+	}
+
+	if db != nil {
+		registry.NewGaugeFunc("blogly_db_open_connections", "Established connections to the database, in use or idle.", func() float64 {
+			return float64(db.Stats().OpenConnections)
+		})
+		registry.NewGaugeFunc("blogly_db_in_use_connections", "Database connections currently in use.", func() float64 {
+			return float64(db.Stats().InUse)
+		})
+		registry.NewGaugeFunc("blogly_db_idle_connections", "Idle database connections.", func() float64 {
+			return float64(db.Stats().Idle)
+		})
+		registry.NewCounterFunc("blogly_db_wait_count_total", "Database connections waited for because the pool was exhausted.", func() float64 {
+			return float64(db.Stats().WaitCount)
+		})
+		registry.NewCounterFunc("blogly_db_wait_duration_seconds_total", "Time spent waiting for a database connection.", func() float64 {
+			return db.Stats().WaitDuration.Seconds()
+		})
+	}
+
+	return m
+}
+
+// metricsMethod keeps unknown methods from creating a series each.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "show post", method: http.MethodGet, path: "/v1/posts/1", status: http.StatusOK},
+		{name: "show other post", method: http.MethodGet, path: "/v1/posts/3", status: http.StatusOK},
+		{name: "show missing post", method: http.MethodGet, path: "/v1/posts/99", status: http.StatusNotFound},
+		{name: "unknown route", method: http.MethodGet, path: "/v1/nope", status: http.StatusNotFound},
+	})
+
+	admin := httptest.NewServer(ts.app.adminRoutes())
+	t.Cleanup(admin.Close)
+
+	res, err := admin.Client().Get(admin.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(b)
+
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("got status %d and content type %q; want 200 and text/plain", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	want := []string{
+		"# TYPE blogly_http_requests_total counter",
+		`blogly_http_requests_total{method="GET",route="/v1/posts/:post_id",status="200"} 2`,
+		`blogly_http_requests_total{method="GET",route="/v1/posts/:post_id",status="404"} 1`,
+		`blogly_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		"# TYPE blogly_http_request_duration_seconds histogram",
+		`blogly_http_request_duration_seconds_bucket{method="GET",route="/v1/posts/:post_id",le="+Inf"} 3`,
+		`blogly_http_request_duration_seconds_count{method="GET",route="/v1/posts/:post_id"} 3`,
+		"blogly_http_requests_in_flight 0",
+		"blogly_rate_limit_rejections_total 0",
+	}
+
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("want metrics to contain %q; got:\n%s", line, body)
+		}
+	}
+}
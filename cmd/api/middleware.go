@@ -3,15 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/manuelam2003/blogly/internal/data"
 	"github.com/manuelam2003/blogly/internal/validator"
-	"golang.org/x/time/rate"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -27,79 +26,73 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// rateLimit limits each client to the configured rate. Authenticated users
+// get a bucket of their own, so that users behind the same address don't
+// share one, and everyone else is limited by IP address. It has to run after
+// authenticate. Requests with an invalid token are counted against their
+// address, and the bucket checkTokenLookup looks at, before being rejected,
+// so that tokens can't be guessed faster than the limit allows.
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-
-			mu.Lock()
-
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-
-			mu.Unlock()
-		}
-	}()
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invalidToken := app.contextHasInvalidToken(r)
+
 		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
+			if invalidToken {
+				app.recordInvalidToken(r)
 			}
 
-			mu.Lock()
+			key := "ip:" + app.clientIP(r)
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
+			if user := app.contextGetUser(r); !user.IsAnonymous() {
+				key = "user:" + strconv.FormatInt(user.ID, 10)
 			}
 
-			clients[ip].lastSeen = time.Now()
-
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			if !app.checkRateLimit(w, r, app.limiter, key) {
 				return
 			}
+		}
 
-			mu.Unlock()
+		if invalidToken {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// rateLimitAuth applies the stricter limit for the authentication endpoint,
+// by IP address, on top of the limit applied by rateLimit.
+func (app *application) rateLimitAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled && !app.checkRateLimit(w, r, app.authLimiter, app.clientIP(r)) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// authenticate looks up the user for the request's bearer token. Requests
+// without one are anonymous. Requests with an invalid token carry on as
+// anonymous, marked for rateLimit to reject, and once a client has sent too
+// many of those its tokens aren't looked up at all.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
 
+		r = app.contextSetUser(r, data.AnonymousUser)
+
 		authorizationHeader := r.Header.Get("Authorization")
 
 		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+			next.ServeHTTP(w, app.contextSetInvalidToken(r))
 			return
 		}
 
@@ -108,15 +101,19 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
+			next.ServeHTTP(w, app.contextSetInvalidToken(r))
+			return
+		}
+
+		if !app.checkTokenLookup(w, r) {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				next.ServeHTTP(w, app.contextSetInvalidToken(r))
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -124,12 +121,30 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) requireAuthorizedUser(next http.HandlerFunc) http.HandlerFunc {
+// touchToken records that the request's authentication token was used. It
+// runs after rateLimit, so that requests turned away don't write to the
+// database.
+func (app *application) touchToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.contextGetUser(r).IsAnonymous() {
+			err := app.models.Tokens.Touch(r.Context(), app.contextGetToken(r))
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
@@ -142,25 +157,198 @@ func (app *application) requireAuthorizedUser(next http.HandlerFunc) http.Handle
 	})
 }
 
+func (app *application) requireAuthorizedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Can(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthorizedUser(fn)
+}
+
+// requestID gives every request an ID, taken from its X-Request-ID header when
+// it carries a usable one, and echoes it back in the response. The ID is added
+// to the request's logger, so every line logged for the request can be tied
+// back to it.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			var err error
+
+			id, err = newRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetLogger(r, app.logger.With("request_id", id))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequest logs one line for each request once it has been served, with
+// the status code, size and duration of the response.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		logger := app.contextGetLogger(r)
+
 		var (
-			ip     = r.RemoteAddr
+			ip     = app.clientIP(r)
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
 		)
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		logger.Debug("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
 
-		next.ServeHTTP(w, r)
+		cw := newCaptureResponseWriter(w)
+
+		next.ServeHTTP(cw, r)
+
+		logger.Info("completed request",
+			"ip", ip,
+			"proto", proto,
+			"method", method,
+			"uri", uri,
+			"route", app.contextGetRoute(r),
+			"status", cw.statusCode,
+			"size", cw.bytesWritten,
+			"duration", time.Since(start).String(),
+		)
 	})
 }
 
+// enableCORS lets browsers on the trusted origins call the API, answering
+// their preflight requests itself. Requests from other origins get no CORS
+// headers, so browsers won't let their scripts read the response.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && slices.Contains(app.config.cors.trustedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "Location, X-Request-ID")
+
+			if app.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+
+				if app.config.cors.maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// captureResponseWriter records the status code and size of the response
+// written through it. It implements Unwrap so that http.ResponseController
+// can still reach the underlying writer.
+type captureResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func newCaptureResponseWriter(w http.ResponseWriter) *captureResponseWriter {
+	return &captureResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (cw *captureResponseWriter) Header() http.Header {
+	return cw.wrapped.Header()
+}
+
+func (cw *captureResponseWriter) WriteHeader(statusCode int) {
+	cw.wrapped.WriteHeader(statusCode)
+
+	if !cw.headerWritten {
+		cw.statusCode = statusCode
+		cw.headerWritten = true
+	}
+}
+
+func (cw *captureResponseWriter) Write(b []byte) (int, error) {
+	cw.headerWritten = true
+
+	n, err := cw.wrapped.Write(b)
+	cw.bytesWritten += n
+
+	return n, err
+}
+
+func (cw *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.wrapped
+}
+
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		// Setting an empty route here lets the router fill it in, so the
+		// request can be counted against its route pattern rather than its
+		// URL, which would give every post its own series.
+		r = app.contextSetRoute(r, "")
+
+		cw := newCaptureResponseWriter(w)
+
+		next.ServeHTTP(cw, r)
+
+		route := app.contextGetRoute(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := metricsMethod(r.Method)
+
+		app.metrics.requests.With(method, route, strconv.Itoa(cw.statusCode)).Inc()
+		app.metrics.duration.With(method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"github.com/manuelam2003/blogly/internal/migrate"
+	"github.com/manuelam2003/blogly/migrations"
+)
+
+const migrateUsage = "usage: api [flags] migrate up|down [n]|status|version"
+
+var errUsage = errors.New(migrateUsage)
+
+// runMigrate runs the migrate subcommand against the database given by
+// -db-dsn, writing its report to w.
+func runMigrate(cfg config, logger *slog.Logger, w io.Writer, args []string) error {
+	steps := 1
+
+	switch {
+	case len(args) == 1 && slices.Contains([]string{"up", "down", "status", "version"}, args[0]):
+	case len(args) == 2 && args[0] == "down":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errUsage
+		}
+		steps = n
+	default:
+		return errUsage
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			logger.Info("applied migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			logger.Info("no migrations to apply", "version", migrator.Latest())
+		}
+
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			logger.Info("reverted migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrator.Migrations() {
+			state := "pending"
+			switch {
+			case m.Version == version && dirty:
+				state = "dirty"
+			case m.Version <= version:
+				state = "applied"
+			}
+
+			fmt.Fprintf(w, "%06d  %-8s %s\n", m.Version, state, m.Name)
+		}
+
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case version == 0:
+			fmt.Fprintln(w, "no migrations applied")
+		case dirty:
+			fmt.Fprintf(w, "%d (dirty)\n", version)
+		default:
+			fmt.Fprintln(w, version)
+		}
+	}
+
+	return nil
+}
+
+// prepareSchema checks that the database schema is one this binary can work
+// with before the server starts, after applying any pending migrations when
+// -migrate-on-start is set.
+func (app *application) prepareSchema(migrator *migrate.Migrator) error {
+	ctx := context.Background()
+
+	if app.config.migrate.onStart {
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			app.logger.Info("applied migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	pending, err := migrator.Check(ctx)
+	if err != nil {
+		return err
+	}
+
+	if pending > 0 {
+		app.logger.Warn("database schema is behind this binary, run api migrate up", "pending", pending, "latest", migrator.Latest())
+	}
+
+	return nil
+}
@@ -20,7 +20,22 @@ func (app *application) addPostTagHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.PostTags.Insert(postID, tagID)
+	if !app.canTagPost(w, r, postID) {
+		return
+	}
+
+	_, err = app.models.Tags.Get(r.Context(), tagID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.PostTags.Insert(r.Context(), postID, tagID)
 	if err != nil {
 		if errors.Is(err, data.ErrDuplicateEntry) {
 			app.conflictResponse(w, r, err)
@@ -49,7 +64,11 @@ func (app *application) deletePostTagHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.PostTags.Delete(postID, tagID)
+	if !app.canTagPost(w, r, postID) {
+		return
+	}
+
+	err = app.models.PostTags.Delete(r.Context(), postID, tagID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -65,3 +84,27 @@ func (app *application) deletePostTagHandler(w http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// canTagPost checks that the current user may change the tags of the post,
+// writing the error response and returning false if not.
+func (app *application) canTagPost(w http.ResponseWriter, r *http.Request, postID int64) bool {
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	if currentUser.ID != post.UserID && !currentUser.Can(data.PermissionPostsModerate) {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/manuelam2003/blogly/internal/data"
 	"github.com/manuelam2003/blogly/internal/validator"
@@ -30,13 +31,18 @@ func (app *application) listPostsHandler(w http.ResponseWriter, r *http.Request)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "user_id", "title", "content", "updated_at", "-id", "-user_id", "-title", "-content", "-updated_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	posts, metadata, err := app.models.Posts.GetAll(input.UserID, input.Title, input.Content, input.Filters)
+	currentUser := app.contextGetUser(r)
+
+	posts, metadata, err := app.models.Posts.GetAll(r.Context(), input.UserID, input.Title, input.Content, currentUser.ID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -55,7 +61,7 @@ func (app *application) showPostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	post, err := app.models.Posts.Get(id)
+	post, err := app.models.Posts.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -66,6 +72,13 @@ func (app *application) showPostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	currentUser := app.contextGetUser(r)
+
+	if !canViewPost(currentUser, post) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -74,8 +87,10 @@ func (app *application) showPostHandler(w http.ResponseWriter, r *http.Request)
 
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string `json:"title"`
-		Content string `json:"content"`
+		Title     string     `json:"title"`
+		Content   string     `json:"content"`
+		Status    string     `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -87,19 +102,31 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 	currentUser := app.contextGetUser(r)
 
 	post := &data.Post{
-		UserID:  currentUser.ID,
-		Title:   input.Title,
-		Content: input.Content,
+		UserID:    currentUser.ID,
+		Title:     input.Title,
+		Content:   input.Content,
+		Status:    input.Status,
+		PublishAt: input.PublishAt,
+	}
+
+	if post.Status == "" {
+		post.Status = data.PostStatusPublished
+		if post.PublishAt != nil {
+			post.Status = data.PostStatusScheduled
+		}
 	}
 
 	v := validator.New()
 
-	if data.ValidatePost(v, post); !v.Valid() {
+	data.ValidatePost(v, post)
+	data.ValidatePublishAt(v, post)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Posts.Insert(post)
+	err = app.models.Posts.Insert(r.Context(), post)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -121,7 +148,7 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	post, err := app.models.Posts.Get(id)
+	post, err := app.models.Posts.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -134,14 +161,16 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 
 	currentUser := app.contextGetUser(r)
 
-	if currentUser.ID != post.UserID {
-		app.invalidUserResponse(w, r)
+	if currentUser.ID != post.UserID && !currentUser.Can(data.PermissionPostsModerate) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
 	var input struct {
-		Title   *string `json:"title"`
-		Content *string `json:"content"`
+		Title     *string    `json:"title"`
+		Content   *string    `json:"content"`
+		Status    *string    `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -158,14 +187,34 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Content = *input.Content
 	}
 
+	// The schedule is only checked when it changes, so that a post which is
+	// due but not yet published can still be edited.
+	rescheduled := false
+
+	if input.Status != nil {
+		rescheduled = *input.Status != post.Status
+		post.Status = *input.Status
+	}
+
+	if input.PublishAt != nil {
+		rescheduled = rescheduled || post.PublishAt == nil || !input.PublishAt.Equal(*post.PublishAt)
+		post.PublishAt = input.PublishAt
+	}
+
 	v := validator.New()
 
-	if data.ValidatePost(v, post); !v.Valid() {
+	data.ValidatePost(v, post)
+
+	if rescheduled {
+		data.ValidatePublishAt(v, post)
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Posts.Update(post)
+	err = app.models.Posts.Update(r.Context(), post)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -191,13 +240,13 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 
 	currentUser := app.contextGetUser(r)
 
-	err = app.models.Posts.Delete(id, currentUser.ID)
+	err = app.models.Posts.Delete(r.Context(), id, currentUser.ID, currentUser.Can(data.PermissionPostsModerate))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrUnauthorized):
-			app.invalidUserResponse(w, r)
+			app.notPermittedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -210,6 +259,102 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (app *application) restorePostHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	err = app.models.Posts.Restore(r.Context(), id, currentUser.ID, currentUser.Can(data.PermissionPostsModerate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnauthorized):
+			app.notPermittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	post, err := app.models.Posts.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) publishPostHandler(w http.ResponseWriter, r *http.Request) {
+	app.setPostStatus(w, r, data.PostStatusPublished)
+}
+
+func (app *application) unpublishPostHandler(w http.ResponseWriter, r *http.Request) {
+	app.setPostStatus(w, r, data.PostStatusDraft)
+}
+
+func (app *application) setPostStatus(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	if currentUser.ID != post.UserID && !currentUser.Can(data.PermissionPostsModerate) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	post.Status = status
+
+	if status == data.PostStatusPublished {
+		now := time.Now()
+		post.PublishAt = &now
+	}
+
+	err = app.models.Posts.Update(r.Context(), post)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) listUserPostsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := app.readIDParam(r, "user_id")
 	if err != nil {
@@ -229,13 +374,18 @@ func (app *application) listUserPostsHandler(w http.ResponseWriter, r *http.Requ
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "user_id", "title", "content", "updated_at", "-id", "-user_id", "-title", "-content", "-updated_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	posts, metadata, err := app.models.Posts.GetAllForUser(userID, input.Filters)
+	currentUser := app.contextGetUser(r)
+
+	posts, metadata, err := app.models.Posts.GetAllForUser(r.Context(), userID, currentUser.ID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -246,3 +396,7 @@ func (app *application) listUserPostsHandler(w http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func canViewPost(user *data.User, post *data.Post) bool {
+	return post.IsPublished() || user.ID == post.UserID || user.Can(data.PermissionPostsModerate)
+}
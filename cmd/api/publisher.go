@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+)
+
+func (app *application) startScheduledPublisher(ctx context.Context) {
+	app.runPeriodically(ctx, app.config.publisher.interval, func(ctx context.Context) {
+		published, err := app.models.Posts.PublishScheduled(ctx)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		if published > 0 {
+			app.logger.Info("published scheduled posts", "count", published)
+		}
+	})
+}
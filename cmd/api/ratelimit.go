@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+// rateLimiter is one of the application's limits. Its buckets live in the
+// shared rate limit store, under keys starting with its prefix.
+type rateLimiter struct {
+	prefix string
+	limit  data.RateLimit
+}
+
+func newRateLimiter(prefix string, rps float64, burst int) rateLimiter {
+	return rateLimiter{
+		prefix: prefix,
+		limit:  data.RateLimit{Rate: rps, Burst: burst},
+	}
+}
+
+// startRateLimiterCleanup regularly removes idle buckets from the rate limit
+// store until ctx is cancelled. A bucket is only removed once it would have
+// refilled anyway, so clients don't get a fresh one early.
+func (app *application) startRateLimiterCleanup(ctx context.Context) {
+	if !app.config.limiter.enabled {
+		return
+	}
+
+	idle := max(app.limiter.limit.FillTime(), app.authLimiter.limit.FillTime())
+
+	app.runPeriodically(ctx, time.Minute, func(ctx context.Context) {
+		deleted, err := app.rateLimits.DeleteIdle(ctx, idle)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		if deleted > 0 {
+			app.logger.Debug("removed idle rate limit buckets", "count", deleted)
+		}
+	})
+}
+
+// checkRateLimit takes a token from the client's bucket and sets the
+// RateLimit-* headers. If the bucket is empty it sends a 429 response with a
+// Retry-After header and returns false. When the store can't be reached the
+// request is let through, so that the limiter doesn't take the API down with
+// it.
+func (app *application) checkRateLimit(w http.ResponseWriter, r *http.Request, limiter rateLimiter, key string) bool {
+	result, err := app.rateLimits.Allow(r.Context(), limiter.prefix+key, limiter.limit)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	return app.applyRateLimit(w, r, result)
+}
+
+// checkTokenLookup reports whether the client's address may have another
+// authentication token looked up. Every invalid token it sends takes from a
+// bucket of its own, and once that is empty its tokens are refused before
+// they reach the database, valid or not, as in checkRateLimit.
+func (app *application) checkTokenLookup(w http.ResponseWriter, r *http.Request) bool {
+	if !app.config.limiter.enabled {
+		return true
+	}
+
+	result, err := app.rateLimits.Peek(r.Context(), app.invalidTokenKey(r), app.limiter.limit)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	return app.applyRateLimit(w, r, result)
+}
+
+// recordInvalidToken takes a token from the bucket checkTokenLookup looks at.
+func (app *application) recordInvalidToken(r *http.Request) {
+	_, err := app.rateLimits.Allow(r.Context(), app.invalidTokenKey(r), app.limiter.limit)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) invalidTokenKey(r *http.Request) string {
+	return app.limiter.prefix + "invalid-token:" + app.clientIP(r)
+}
+
+// applyRateLimit sets the RateLimit-* headers from the result, and sends a
+// 429 response with a Retry-After header if the request isn't allowed.
+func (app *application) applyRateLimit(w http.ResponseWriter, r *http.Request, result data.RateLimitResult) bool {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+
+		app.metrics.rateLimited.Inc()
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// clientIP returns the IP address of the client that sent the request. When
+// the request comes from a trusted proxy, the addresses the proxies added to
+// the Forwarded or X-Forwarded-For header are walked from the nearest one,
+// and the first address that isn't a trusted proxy is the client. Addresses
+// further along were supplied by the client itself and can't be trusted.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !app.trustedProxy(addr) {
+		return host
+	}
+
+	hops := forwardedHops(r.Header)
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseForwardedHop(hops[i])
+		if err != nil {
+			break
+		}
+
+		addr = hop
+
+		if !app.trustedProxy(addr) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range app.config.proxies.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedHops returns the addresses from the Forwarded header, or from
+// X-Forwarded-For if there is no Forwarded header, in the order they were
+// added.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				hop := "unknown"
+
+				for _, pair := range strings.Split(element, ";") {
+					name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(name, "for") {
+						hop = value
+					}
+				}
+
+				hops = append(hops, hop)
+			}
+		}
+
+		return hops
+	}
+
+	for _, value := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	return hops
+}
+
+// parseForwardedHop parses an address as a proxy writes it, possibly quoted,
+// bracketed and with a port, as in `"[2001:db8::1]:4711"`.
+func parseForwardedHop(hop string) (netip.Addr, error) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+
+	addrPort, err := netip.ParseAddrPort(hop)
+	if err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap(), nil
+}
+
+// parseTrustedProxies parses a space separated list of IP addresses and CIDR
+// ranges.
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Fields(val) {
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	var err error
+	app.config.proxies.trusted, err = parseTrustedProxies("10.0.0.0/8 2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted peer's header is ignored", remoteAddr: "203.0.113.7:5000", header: "X-Forwarded-For", value: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "x-forwarded-for", remoteAddr: "10.0.0.1:5000", header: "X-Forwarded-For", value: "198.51.100.1", want: "198.51.100.1"},
+		{name: "x-forwarded-for through two proxies", remoteAddr: "10.0.0.1:5000", header: "X-Forwarded-For", value: "198.51.100.1, 10.1.2.3", want: "198.51.100.1"},
+		{name: "spoofed x-forwarded-for", remoteAddr: "10.0.0.1:5000", header: "X-Forwarded-For", value: "1.1.1.1, 198.51.100.1", want: "198.51.100.1"},
+		{name: "malformed x-forwarded-for", remoteAddr: "10.0.0.1:5000", header: "X-Forwarded-For", value: "nonsense", want: "10.0.0.1"},
+		{name: "forwarded", remoteAddr: "10.0.0.1:5000", header: "Forwarded", value: `for=198.51.100.1;proto=https, for="10.1.2.3:8080"`, want: "198.51.100.1"},
+		{name: "forwarded ipv6", remoteAddr: "[2001:db8::1]:5000", header: "Forwarded", value: `for="[2001:db8::cafe]:4711"`, want: "2001:db8::cafe"},
+		{name: "forwarded unknown", remoteAddr: "10.0.0.1:5000", header: "Forwarded", value: "for=unknown", want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.limiter.enabled = true
+	ts.app.limiter = newRateLimiter("api:", 0.001, 2)
+	ts.app.authLimiter = newRateLimiter("auth:", 0.001, 1)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		user       string
+		body       string
+		status     int
+		remaining  string
+		retryAfter bool
+	}{
+		{name: "anonymous", method: http.MethodGet, path: "/v1/healthcheck", status: http.StatusOK, remaining: "1"},
+		{name: "anonymous again", method: http.MethodGet, path: "/v1/healthcheck", status: http.StatusOK, remaining: "0"},
+		{name: "anonymous limited", method: http.MethodGet, path: "/v1/healthcheck", status: http.StatusTooManyRequests, remaining: "0", retryAfter: true},
+		{name: "user has own bucket", method: http.MethodGet, path: "/v1/healthcheck", user: "alice", status: http.StatusOK, remaining: "1"},
+		{name: "other user has own bucket", method: http.MethodGet, path: "/v1/healthcheck", user: "bob", status: http.StatusOK, remaining: "1"},
+		{name: "login", method: http.MethodPost, path: "/v1/tokens/authentication", user: "bob", body: `{"email":"bob@example.com","password":"wrongpa55word"}`, status: http.StatusUnauthorized, remaining: "0"},
+		{name: "login limited", method: http.MethodPost, path: "/v1/tokens/authentication", user: "mod", body: `{"email":"bob@example.com","password":"wrongpa55word"}`, status: http.StatusTooManyRequests, remaining: "0", retryAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, headers, body := ts.do(t, tt.method, tt.path, tt.user, tt.body)
+
+			if status != tt.status {
+				t.Errorf("got status %d; want %d; body: %s", status, tt.status, body)
+			}
+
+			if got := headers.Get("RateLimit-Remaining"); got != tt.remaining {
+				t.Errorf("got RateLimit-Remaining %q; want %q", got, tt.remaining)
+			}
+
+			if headers.Get("RateLimit-Limit") == "" || headers.Get("RateLimit-Reset") == "" {
+				t.Errorf("got headers %v; want RateLimit-Limit and RateLimit-Reset", headers)
+			}
+
+			if got := headers.Get("Retry-After"); (got != "") != tt.retryAfter {
+				t.Errorf("got Retry-After %q; want it set: %t", got, tt.retryAfter)
+			}
+		})
+	}
+}
+
+// Requests with a bad token are counted against the client's address before
+// being rejected, so tokens can't be guessed faster than the limit. Once the
+// address has sent too many, its tokens aren't looked up any more, valid or
+// not.
+func TestRateLimitInvalidTokens(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.limiter.enabled = true
+	ts.app.limiter = newRateLimiter("api:", 0.001, 2)
+
+	ts.tokens["mallory"] = "AAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	ts.run(t, []routeTest{
+		{name: "bad token", method: http.MethodGet, path: "/v1/posts", user: "mallory", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+		{name: "bad token again", method: http.MethodGet, path: "/v1/posts", user: "mallory", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+		{name: "bad token limited", method: http.MethodGet, path: "/v1/posts", user: "mallory", status: http.StatusTooManyRequests},
+		{name: "anonymous shares the address", method: http.MethodGet, path: "/v1/posts", status: http.StatusTooManyRequests},
+		{name: "tokens from the address are not looked up", method: http.MethodGet, path: "/v1/posts", user: "alice", status: http.StatusTooManyRequests},
+	})
+}
+
+// TestRateLimitSharedStore runs several instances of the application against
+// one store, as replicas sharing a database would, and checks that a client
+// gets the configured limit in total rather than once per instance.
+func TestRateLimitSharedStore(t *testing.T) {
+	store := data.NewMemoryRateLimitStore()
+
+	instances := make([]*testServer, 3)
+	for i := range instances {
+		ts := newTestServer(t)
+		ts.app.config.limiter.enabled = true
+		ts.app.rateLimits = store
+		ts.app.limiter = newRateLimiter("api:", 0.001, 4)
+		instances[i] = ts
+	}
+
+	var allowed, limited int
+
+	for i := range 9 {
+		status, headers, body := instances[i%len(instances)].do(t, http.MethodGet, "/v1/healthcheck", "", "")
+
+		switch status {
+		case http.StatusOK:
+			allowed++
+		case http.StatusTooManyRequests:
+			limited++
+		default:
+			t.Fatalf("got status %d; want 200 or 429; body: %s", status, body)
+		}
+
+		if want := strconv.Itoa(max(4-(i+1), 0)); headers.Get("RateLimit-Remaining") != want {
+			t.Errorf("request %d: got RateLimit-Remaining %q; want %q", i+1, headers.Get("RateLimit-Remaining"), want)
+		}
+	}
+
+	if allowed != 4 || limited != 5 {
+		t.Errorf("got %d allowed and %d limited; want 4 and 5", allowed, limited)
+	}
+
+	// Users are limited across instances too, each in their own bucket.
+	for i, ts := range instances {
+		status, _, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", "alice", "")
+		if status != http.StatusOK {
+			t.Errorf("instance %d: got status %d for alice; want 200", i, status)
+		}
+	}
+
+	status, _, _ := instances[0].do(t, http.MethodGet, "/v1/healthcheck", "alice", "")
+	if status != http.StatusOK {
+		t.Errorf("got status %d for alice's 4th request; want 200", status)
+	}
+
+	status, _, _ = instances[1].do(t, http.MethodGet, "/v1/healthcheck", "alice", "")
+	if status != http.StatusTooManyRequests {
+		t.Errorf("got status %d for alice's 5th request; want 429", status)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/diff"
+	"github.com/manuelam2003/blogly/internal/validator"
+)
+
+func (app *application) listPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canViewPost(app.contextGetUser(r), post) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAllForPost(r.Context(), postID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) diffPostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revisionID, err := app.readIDParam(r, "rev_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canViewPost(app.contextGetUser(r), post) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	againstID := int64(app.readInt(r.URL.Query(), "against", 0, v))
+	v.Check(againstID >= 0, "against", "must be a revision id")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(r.Context(), postID, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Without ?against= the revision is compared with the one before it, and
+	// the first revision of a post is compared with an empty document.
+	var against *data.PostRevision
+
+	if againstID > 0 {
+		against, err = app.models.Revisions.Get(r.Context(), postID, againstID)
+	} else {
+		against, err = app.models.Revisions.GetPrevious(r.Context(), postID, revisionID)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			against, err = &data.PostRevision{PostID: postID}, nil
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("against", "must be a revision of the same post")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	againstText := ""
+	if against.ID > 0 {
+		againstText = against.Text()
+	}
+
+	unified, err := diff.Unified(
+		fmt.Sprintf("revision %d", against.ID),
+		fmt.Sprintf("revision %d", revision.ID),
+		againstText,
+		revision.Text(),
+		3,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, diff.ErrTooLarge):
+			v.AddError("against", "revisions differ in too many lines to compare")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"diff": map[string]any{
+			"from":    against.ID,
+			"to":      revision.ID,
+			"unified": unified,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restorePostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r, "post_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revisionID, err := app.readIDParam(r, "rev_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	if currentUser.ID != post.UserID && !currentUser.Can(data.PermissionPostsModerate) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(r.Context(), postID, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Restoring never rewrites history: the old title and content are saved
+	// again as the newest revision.
+	post.Title = revision.Title
+	post.Content = revision.Content
+
+	err = app.models.Posts.Update(r.Context(), post)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/manuelam2003/blogly/internal/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -12,39 +13,84 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/posts", app.listPostsHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/posts/:post_id", app.showPostHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/posts", app.requireAuthorizedUser(app.createPostHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/posts/:post_id", app.requireAuthorizedUser(app.updatePostHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/posts/:post_id", app.requireAuthorizedUser(app.deletePostHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/users/:user_id/posts", app.listUserPostsHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/users", app.listUsersHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/users/:user_id", app.showUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.createUserHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/users/:user_id", app.requireAuthorizedUser(app.updateUserHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/users/:user_id", app.requireAuthorizedUser(app.deleteUserHandler))
-
-	router.HandlerFunc(http.MethodGet, "/v1/posts/:post_id/comments", app.listPostCommentsHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/posts/:post_id/comments/:comment_id", app.showCommentHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/posts/:post_id/comments", app.requireAuthorizedUser(app.createCommentHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/posts/:post_id/comments/:comment_id", app.requireAuthorizedUser(app.updateCommentHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/posts/:post_id/comments/:comment_id", app.requireAuthorizedUser(app.deleteCommentHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/users/:user_id/comments", app.listUserCommentsHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/tags", app.listTagsHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/tags/:tag_id", app.showTagHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tags", app.createTagHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/tags/:tag_id", app.updateTagHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/tags/:tag_id", app.deleteTagHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/posts/:post_id/tags", app.listPostTagsHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/posts/:post_id/tags/:tag_id", app.addPostTagHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/posts/:post_id/tags/:tag_id", app.deletePostTagHandler)
-
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-
-	return app.recoverPanic(app.enableCORS(app.logRequest(app.rateLimit(app.authenticate(router)))))
+	// handle registers a route and records its pattern in the request
+	// context, so that errors can be reported against the route rather than
+	// the raw URL.
+	handle := func(method, path string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, path, func(w http.ResponseWriter, r *http.Request) {
+			handler(w, app.contextSetRoute(r, path))
+		})
+	}
+
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", app.livenessHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
+
+	handle(http.MethodGet, "/v1/posts", app.listPostsHandler)
+	handle(http.MethodGet, "/v1/posts/:post_id", app.showPostHandler)
+	handle(http.MethodPost, "/v1/posts", app.requirePermission(data.PermissionPostsWrite, app.createPostHandler))
+	handle(http.MethodPatch, "/v1/posts/:post_id", app.requirePermission(data.PermissionPostsWrite, app.updatePostHandler))
+	handle(http.MethodDelete, "/v1/posts/:post_id", app.requirePermission(data.PermissionPostsWrite, app.deletePostHandler))
+	handle(http.MethodPost, "/v1/posts/:post_id/restore", app.requirePermission(data.PermissionPostsWrite, app.restorePostHandler))
+	handle(http.MethodPost, "/v1/posts/:post_id/publish", app.requirePermission(data.PermissionPostsWrite, app.publishPostHandler))
+	handle(http.MethodPost, "/v1/posts/:post_id/unpublish", app.requirePermission(data.PermissionPostsWrite, app.unpublishPostHandler))
+	handle(http.MethodGet, "/v1/users/:user_id/posts", app.listUserPostsHandler)
+
+	handle(http.MethodGet, "/v1/posts/:post_id/revisions", app.listPostRevisionsHandler)
+	handle(http.MethodGet, "/v1/posts/:post_id/revisions/:rev_id/diff", app.diffPostRevisionHandler)
+	handle(http.MethodPost, "/v1/posts/:post_id/revisions/:rev_id/restore", app.requirePermission(data.PermissionPostsWrite, app.restorePostRevisionHandler))
+
+	handle(http.MethodGet, "/v1/users", app.listUsersHandler)
+	handle(http.MethodGet, "/v1/users/:user_id", app.showUserHandler)
+	handle(http.MethodPost, "/v1/users", app.createUserHandler)
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPut, "/v1/users/password", app.resetUserPasswordHandler)
+	handle(http.MethodPatch, "/v1/users/:user_id", app.requireAuthorizedUser(app.updateUserHandler))
+	handle(http.MethodDelete, "/v1/users/:user_id", app.requireAuthorizedUser(app.deleteUserHandler))
+	handle(http.MethodPost, "/v1/users/:user_id/restore", app.requirePermission(data.PermissionUsersManage, app.restoreUserHandler))
+	handle(http.MethodPatch, "/v1/users/:user_id/role", app.requirePermission(data.PermissionUsersManage, app.updateUserRoleHandler))
+	handle(http.MethodPost, "/v1/users/:user_id/unlock", app.requirePermission(data.PermissionUsersManage, app.unlockUserHandler))
+	handle(http.MethodGet, "/v1/users/:user_id/audit", app.requirePermission(data.PermissionUsersManage, app.listUserAuditHandler))
+
+	handle(http.MethodGet, "/v1/posts/:post_id/comments", app.listPostCommentsHandler)
+	handle(http.MethodGet, "/v1/posts/:post_id/comments/:comment_id", app.showCommentHandler)
+	handle(http.MethodPost, "/v1/posts/:post_id/comments", app.requirePermission(data.PermissionCommentsWrite, app.createCommentHandler))
+	handle(http.MethodPatch, "/v1/posts/:post_id/comments/:comment_id", app.requirePermission(data.PermissionCommentsWrite, app.updateCommentHandler))
+	handle(http.MethodDelete, "/v1/posts/:post_id/comments/:comment_id", app.requirePermission(data.PermissionCommentsWrite, app.deleteCommentHandler))
+	handle(http.MethodPost, "/v1/posts/:post_id/comments/:comment_id/restore", app.requirePermission(data.PermissionCommentsWrite, app.restoreCommentHandler))
+	handle(http.MethodGet, "/v1/users/:user_id/comments", app.listUserCommentsHandler)
+
+	handle(http.MethodGet, "/v1/trash", app.requireAuthenticatedUser(app.listTrashHandler))
+
+	handle(http.MethodGet, "/v1/tags", app.listTagsHandler)
+	handle(http.MethodGet, "/v1/tags/:tag_id", app.showTagHandler)
+	handle(http.MethodPost, "/v1/tags", app.requirePermission(data.PermissionTagsWrite, app.createTagHandler))
+	handle(http.MethodPatch, "/v1/tags/:tag_id", app.requirePermission(data.PermissionTagsWrite, app.updateTagHandler))
+	handle(http.MethodDelete, "/v1/tags/:tag_id", app.requirePermission(data.PermissionTagsWrite, app.deleteTagHandler))
+
+	handle(http.MethodGet, "/v1/posts/:post_id/tags", app.listPostTagsHandler)
+	handle(http.MethodPost, "/v1/posts/:post_id/tags/:tag_id", app.requirePermission(data.PermissionPostsWrite, app.addPostTagHandler))
+	handle(http.MethodDelete, "/v1/posts/:post_id/tags/:tag_id", app.requirePermission(data.PermissionPostsWrite, app.deletePostTagHandler))
+
+	handle(http.MethodGet, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.listAuthenticationTokensHandler))
+	handle(http.MethodPost, "/v1/tokens/authentication", app.rateLimitAuth(app.createAuthenticationTokenHandler))
+	handle(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	handle(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
+	handle(http.MethodPost, "/v1/tokens/refresh", app.createRefreshTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	return app.recordMetrics(app.requestID(app.logRequest(app.recoverPanic(app.enableCORS(app.authenticate(app.rateLimit(app.touchToken(router))))))))
+}
+
+// adminRoutes serves /metrics on the admin listener given by -metrics-addr,
+// away from the public API. The health probes are served there too, outside
+// the public rate limit, for checks that can reach the listener.
+func (app *application) adminRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.Handler(http.MethodGet, "/metrics", app.metrics.registry.Handler())
+	router.HandlerFunc(http.MethodGet, "/healthcheck/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/healthcheck/ready", app.readinessHandler)
+
+	return app.recoverPanic(router)
 }
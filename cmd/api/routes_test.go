@@ -0,0 +1,794 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+const (
+	msgNotFound      = "the requested resource could not be found"
+	msgAuthRequired  = "you must be authenticated to access this resource"
+	msgInvalidToken  = "invalid or missing authentication token"
+	msgInactive      = "your user account must be activated to access this resource"
+	msgNotPermitted  = "your user account doesn't have the necessary permissions to access this resource"
+	msgEditConflict  = "unable to update the record due to an edit conflict, please try again"
+	msgInvalidCursor = "must be a valid cursor for this sort"
+)
+
+func TestHealthcheckRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "available", method: http.MethodGet, path: "/v1/healthcheck", status: http.StatusOK, contains: []string{"available", "testing"}},
+		{name: "method not allowed", method: http.MethodPost, path: "/v1/healthcheck", status: http.StatusMethodNotAllowed},
+		{name: "unknown route", method: http.MethodGet, path: "/v1/nope", status: http.StatusNotFound, contains: []string{msgNotFound}},
+		{name: "authenticated", method: http.MethodGet, path: "/v1/healthcheck", user: "alice", status: http.StatusOK},
+	})
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/healthcheck", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []string{"Token abc", "Bearer short", "Bearer AAAAAAAAAAAAAAAAAAAAAAAAAA"} {
+		req.Header.Set("Authorization", header)
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q: got status %d; want 401 with a WWW-Authenticate header", header, res.StatusCode)
+		}
+	}
+}
+
+func TestHealthcheckProbes(t *testing.T) {
+	ts := newTestServer(t)
+	db := ts.app.db.(*testDB)
+	schema := ts.app.schema.(*testSchema)
+
+	// The probes are also served on the admin listener, at the same paths
+	// without the version prefix.
+	admin := &testServer{Server: httptest.NewServer(ts.app.adminRoutes()), app: ts.app}
+	t.Cleanup(admin.Close)
+
+	ts.run(t, []routeTest{
+		{name: "live", method: http.MethodGet, path: "/v1/healthcheck/live", status: http.StatusOK, contains: []string{`"status": "alive"`}},
+		{name: "ready", method: http.MethodGet, path: "/v1/healthcheck/ready", status: http.StatusOK, contains: []string{`"status": "available"`, `"max_open_connections": 25`, `"version": 14`, `"go_version": "go`, `"commit": "`}},
+	})
+
+	admin.run(t, []routeTest{
+		{name: "admin live", method: http.MethodGet, path: "/healthcheck/live", status: http.StatusOK, contains: []string{`"status": "alive"`}},
+		{name: "admin ready", method: http.MethodGet, path: "/healthcheck/ready", status: http.StatusOK, contains: []string{`"status": "available"`}},
+	})
+
+	db.err = errors.New("connection refused")
+
+	ts.run(t, []routeTest{
+		{name: "database down", method: http.MethodGet, path: "/v1/healthcheck/ready", status: http.StatusServiceUnavailable, contains: []string{`"status": "unavailable"`, `"status": "down"`}, excludes: []string{"connection refused"}},
+		{name: "still alive", method: http.MethodGet, path: "/v1/healthcheck/live", status: http.StatusOK},
+	})
+
+	db.err = nil
+	schema.dirty = true
+
+	ts.run(t, []routeTest{
+		{name: "dirty schema", method: http.MethodGet, path: "/v1/healthcheck/ready", status: http.StatusServiceUnavailable, contains: []string{`"dirty": true`}},
+	})
+
+	schema.dirty = false
+	ts.app.shuttingDown.Store(true)
+
+	ts.run(t, []routeTest{
+		{name: "shutting down", method: http.MethodGet, path: "/v1/healthcheck/ready", status: http.StatusServiceUnavailable, contains: []string{`"reason": "shutting down"`}},
+		{name: "alive while shutting down", method: http.MethodGet, path: "/v1/healthcheck/live", status: http.StatusOK},
+	})
+
+	admin.run(t, []routeTest{
+		{name: "admin shutting down", method: http.MethodGet, path: "/healthcheck/ready", status: http.StatusServiceUnavailable, contains: []string{`"reason": "shutting down"`}},
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+		reused bool
+	}{
+		{name: "generated", header: "", reused: false},
+		{name: "propagated", header: "abc-123.def", reused: true},
+		{name: "unsafe is replaced", header: "abc\" level=ERROR", reused: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/healthcheck", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			id := res.Header.Get("X-Request-ID")
+
+			if !validRequestID(id) || (id == tt.header) != tt.reused {
+				t.Errorf("sent X-Request-ID %q, got %q back", tt.header, id)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.app.config.cors.trustedOrigins = []string{"https://blogly.example", "https://admin.blogly.example"}
+	ts.app.config.cors.maxAge = time.Minute
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		status        int
+		allowOrigin   string
+		allowMethods  string
+		maxAge        string
+	}{
+		{name: "no origin", method: http.MethodGet, status: http.StatusOK},
+		{name: "trusted origin", method: http.MethodGet, origin: "https://admin.blogly.example", status: http.StatusOK, allowOrigin: "https://admin.blogly.example"},
+		{name: "untrusted origin", method: http.MethodGet, origin: "https://evil.example", status: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, origin: "https://blogly.example", requestMethod: http.MethodDelete, status: http.StatusNoContent, allowOrigin: "https://blogly.example", allowMethods: "OPTIONS, GET, POST, PUT, PATCH, DELETE", maxAge: "60"},
+		{name: "untrusted preflight", method: http.MethodOptions, origin: "https://evil.example", requestMethod: http.MethodDelete, status: http.StatusOK},
+		{name: "plain options", method: http.MethodOptions, origin: "https://blogly.example", status: http.StatusOK, allowOrigin: "https://blogly.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+"/v1/posts/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.status {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.status)
+			}
+
+			headers := []struct{ name, want string }{
+				{"Access-Control-Allow-Origin", tt.allowOrigin},
+				{"Access-Control-Allow-Methods", tt.allowMethods},
+				{"Access-Control-Max-Age", tt.maxAge},
+				{"Access-Control-Allow-Credentials", ""},
+			}
+
+			for _, h := range headers {
+				if got := res.Header.Get(h.name); got != h.want {
+					t.Errorf("got %s %q; want %q", h.name, got, h.want)
+				}
+			}
+
+			if !slices.Contains(res.Header.Values("Vary"), "Origin") {
+				t.Errorf("got Vary %q; want it to include Origin", res.Header.Values("Vary"))
+			}
+		})
+	}
+}
+
+func TestPostRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "list hides drafts from anonymous users", method: http.MethodGet, path: "/v1/posts", status: http.StatusOK, contains: []string{"Hello world", "Bob on databases"}, excludes: []string{"Secret draft"}},
+		{name: "list shows drafts to their author", method: http.MethodGet, path: "/v1/posts", user: "alice", status: http.StatusOK, contains: []string{"Secret draft"}},
+		{name: "list filters by title", method: http.MethodGet, path: "/v1/posts?title=databases", status: http.StatusOK, contains: []string{"Bob on databases"}, excludes: []string{"Hello world"}},
+		{name: "list rejects page zero", method: http.MethodGet, path: "/v1/posts?page=0", status: http.StatusUnprocessableEntity, contains: []string{"must be greater than zero"}},
+		{name: "list rejects large page size", method: http.MethodGet, path: "/v1/posts?page_size=101", status: http.StatusUnprocessableEntity, contains: []string{"must be a maximum of 100"}},
+		{name: "list rejects unknown sort", method: http.MethodGet, path: "/v1/posts?sort=author", status: http.StatusUnprocessableEntity, contains: []string{"invalid sort value"}},
+		{name: "list rejects non-integer user", method: http.MethodGet, path: "/v1/posts?user_id=x", status: http.StatusUnprocessableEntity, contains: []string{"must be an integer value"}},
+		{name: "list rejects bad cursor", method: http.MethodGet, path: "/v1/posts?after=garbage", status: http.StatusUnprocessableEntity, contains: []string{msgInvalidCursor}},
+
+		{name: "show published", method: http.MethodGet, path: "/v1/posts/1", status: http.StatusOK, contains: []string{"Hello world"}},
+		{name: "show draft anonymous", method: http.MethodGet, path: "/v1/posts/2", status: http.StatusNotFound},
+		{name: "show draft other user", method: http.MethodGet, path: "/v1/posts/2", user: "bob", status: http.StatusNotFound},
+		{name: "show draft author", method: http.MethodGet, path: "/v1/posts/2", user: "alice", status: http.StatusOK, contains: []string{"Secret draft"}},
+		{name: "show draft moderator", method: http.MethodGet, path: "/v1/posts/2", user: "mod", status: http.StatusOK},
+		{name: "show missing", method: http.MethodGet, path: "/v1/posts/99", status: http.StatusNotFound},
+		{name: "show invalid id", method: http.MethodGet, path: "/v1/posts/abc", status: http.StatusNotFound},
+
+		{name: "create anonymous", method: http.MethodPost, path: "/v1/posts", body: `{"title":"t","content":"c"}`, status: http.StatusUnauthorized, contains: []string{msgAuthRequired}},
+		{name: "create inactive", method: http.MethodPost, path: "/v1/posts", user: "inactive", body: `{"title":"t","content":"c"}`, status: http.StatusForbidden, contains: []string{msgInactive}},
+		{name: "create without permission", method: http.MethodPost, path: "/v1/posts", user: "reader", body: `{"title":"t","content":"c"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "create malformed json", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":`, status: http.StatusBadRequest},
+		{name: "create unknown field", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"t","content":"c","author":"x"}`, status: http.StatusBadRequest, contains: []string{"unknown key"}},
+		{name: "create empty", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"","content":""}`, status: http.StatusUnprocessableEntity, contains: []string{`"title": "must be provided"`, `"content": "must be provided"`}},
+		{name: "create invalid status", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"t","content":"c","status":"hidden"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be one of draft, scheduled, published or archived"}},
+		{name: "create scheduled without date", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"t","content":"c","status":"scheduled"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be provided for scheduled posts"}},
+		{name: "create scheduled in the past", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"t","content":"c","publish_at":"2001-01-01T00:00:00Z"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be in the future"}},
+		{name: "create", method: http.MethodPost, path: "/v1/posts", user: "alice", body: `{"title":"Another post","content":"More words"}`, status: http.StatusCreated, contains: []string{"Another post", `"id": 4`}},
+
+		{name: "update anonymous", method: http.MethodPatch, path: "/v1/posts/1", body: `{"title":"x"}`, status: http.StatusUnauthorized},
+		{name: "update not owner", method: http.MethodPatch, path: "/v1/posts/1", user: "bob", body: `{"title":"x"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "update missing", method: http.MethodPatch, path: "/v1/posts/99", user: "alice", body: `{"title":"x"}`, status: http.StatusNotFound},
+		{name: "update empty title", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"title":""}`, status: http.StatusUnprocessableEntity, contains: []string{"must be provided"}},
+		{name: "update", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"title":"Hello again"}`, status: http.StatusOK, contains: []string{"Hello again"}},
+		{name: "update as moderator", method: http.MethodPatch, path: "/v1/posts/1", user: "mod", body: `{"content":"Moderated"}`, status: http.StatusOK, contains: []string{"Moderated"}},
+
+		{name: "unpublish not owner", method: http.MethodPost, path: "/v1/posts/1/unpublish", user: "bob", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "unpublish", method: http.MethodPost, path: "/v1/posts/1/unpublish", user: "alice", status: http.StatusOK, contains: []string{"draft"}},
+		{name: "unpublished is hidden", method: http.MethodGet, path: "/v1/posts/1", status: http.StatusNotFound},
+		{name: "publish anonymous", method: http.MethodPost, path: "/v1/posts/1/publish", status: http.StatusUnauthorized},
+		{name: "publish", method: http.MethodPost, path: "/v1/posts/1/publish", user: "alice", status: http.StatusOK, contains: []string{"published"}},
+		{name: "publish missing", method: http.MethodPost, path: "/v1/posts/99/publish", user: "alice", status: http.StatusNotFound},
+
+		{name: "list for user hides drafts", method: http.MethodGet, path: "/v1/users/1/posts", status: http.StatusOK, contains: []string{"Hello again"}, excludes: []string{"Secret draft", "Bob on databases"}},
+		{name: "update on draft not owner", method: http.MethodPatch, path: "/v1/posts/2/comments/5", user: "bob", body: `{"content":"x"}`, status: http.StatusNotFound},
+		{name: "list for user shows own drafts", method: http.MethodGet, path: "/v1/users/1/posts", user: "alice", status: http.StatusOK, contains: []string{"Secret draft"}},
+		{name: "list for user rejects bad filters", method: http.MethodGet, path: "/v1/users/1/posts?page_size=0", status: http.StatusUnprocessableEntity, contains: []string{"must be greater than zero"}},
+
+		{name: "delete anonymous", method: http.MethodDelete, path: "/v1/posts/3", status: http.StatusUnauthorized},
+		{name: "delete not owner", method: http.MethodDelete, path: "/v1/posts/3", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "delete missing", method: http.MethodDelete, path: "/v1/posts/99", user: "bob", status: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/v1/posts/3", user: "bob", status: http.StatusOK},
+		{name: "deleted is hidden", method: http.MethodGet, path: "/v1/posts/3", status: http.StatusNotFound},
+		{name: "delete twice", method: http.MethodDelete, path: "/v1/posts/3", user: "bob", status: http.StatusNotFound},
+		{name: "restore not owner", method: http.MethodPost, path: "/v1/posts/3/restore", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "restore", method: http.MethodPost, path: "/v1/posts/3/restore", user: "bob", status: http.StatusOK, contains: []string{"Bob on databases"}},
+		{name: "restore not deleted", method: http.MethodPost, path: "/v1/posts/3/restore", user: "bob", status: http.StatusNotFound},
+		{name: "delete as moderator", method: http.MethodDelete, path: "/v1/posts/3", user: "mod", status: http.StatusOK},
+		{name: "restore as moderator", method: http.MethodPost, path: "/v1/posts/3/restore", user: "mod", status: http.StatusOK},
+	})
+}
+
+// A scheduled post whose time has come stays scheduled until the publisher
+// runs, and can still be edited in the meantime.
+func TestUpdateDuePost(t *testing.T) {
+	ts := newTestServer(t)
+
+	due := time.Now().Add(-time.Minute)
+
+	err := ts.app.models.Posts.Insert(context.Background(), &data.Post{UserID: 1, Title: "Due", Content: "Any minute now", Status: data.PostStatusScheduled, PublishAt: &due})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.run(t, []routeTest{
+		{name: "edit", method: http.MethodPatch, path: "/v1/posts/4", user: "alice", body: `{"title":"Due soon"}`, status: http.StatusOK, contains: []string{"Due soon", `"status": "scheduled"`}},
+		{name: "reschedule in the past", method: http.MethodPatch, path: "/v1/posts/4", user: "alice", body: `{"publish_at":"2001-01-01T00:00:00Z"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be in the future"}},
+		{name: "reschedule", method: http.MethodPatch, path: "/v1/posts/4", user: "alice", body: `{"publish_at":"2099-01-01T00:00:00Z"}`, status: http.StatusOK, contains: []string{"2099-01-01"}},
+		{name: "schedule draft in the past", method: http.MethodPatch, path: "/v1/posts/2", user: "alice", body: `{"status":"scheduled","publish_at":"2001-01-01T00:00:00Z"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be in the future"}},
+	})
+}
+
+func TestRevisionRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "edit", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"content":"The edited post"}`, status: http.StatusOK},
+
+		{name: "list", method: http.MethodGet, path: "/v1/posts/1/revisions", status: http.StatusOK, contains: []string{"The first post", "The edited post", `"total_records": 2`}},
+		{name: "list draft anonymous", method: http.MethodGet, path: "/v1/posts/2/revisions", status: http.StatusNotFound},
+		{name: "list draft author", method: http.MethodGet, path: "/v1/posts/2/revisions", user: "alice", status: http.StatusOK, contains: []string{"Not ready yet"}},
+		{name: "list missing post", method: http.MethodGet, path: "/v1/posts/99/revisions", status: http.StatusNotFound},
+		{name: "list rejects bad filters", method: http.MethodGet, path: "/v1/posts/1/revisions?sort=title", status: http.StatusUnprocessableEntity, contains: []string{"invalid sort value"}},
+
+		{name: "diff with previous", method: http.MethodGet, path: "/v1/posts/1/revisions/4/diff", status: http.StatusOK, contains: []string{`"from": 1`, `"to": 4`, "-The first post", "+The edited post"}},
+		{name: "diff first revision", method: http.MethodGet, path: "/v1/posts/1/revisions/1/diff", status: http.StatusOK, contains: []string{`"from": 0`, "+The first post"}},
+		{name: "diff against", method: http.MethodGet, path: "/v1/posts/1/revisions/1/diff?against=4", status: http.StatusOK, contains: []string{`"from": 4`, `"to": 1`}},
+		{name: "diff against other post", method: http.MethodGet, path: "/v1/posts/1/revisions/4/diff?against=3", status: http.StatusUnprocessableEntity, contains: []string{"must be a revision of the same post"}},
+		{name: "diff against non-integer", method: http.MethodGet, path: "/v1/posts/1/revisions/4/diff?against=x", status: http.StatusUnprocessableEntity, contains: []string{"must be an integer value"}},
+		{name: "diff revision of other post", method: http.MethodGet, path: "/v1/posts/1/revisions/3/diff", status: http.StatusNotFound},
+		{name: "diff draft anonymous", method: http.MethodGet, path: "/v1/posts/2/revisions/2/diff", status: http.StatusNotFound},
+
+		{name: "restore anonymous", method: http.MethodPost, path: "/v1/posts/1/revisions/1/restore", status: http.StatusUnauthorized},
+		{name: "restore without permission", method: http.MethodPost, path: "/v1/posts/1/revisions/1/restore", user: "reader", status: http.StatusForbidden},
+		{name: "restore not owner", method: http.MethodPost, path: "/v1/posts/1/revisions/1/restore", user: "bob", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "restore missing revision", method: http.MethodPost, path: "/v1/posts/1/revisions/99/restore", user: "alice", status: http.StatusNotFound},
+		{name: "restore", method: http.MethodPost, path: "/v1/posts/1/revisions/1/restore", user: "alice", status: http.StatusOK, contains: []string{"The first post"}},
+		{name: "restore adds a revision", method: http.MethodGet, path: "/v1/posts/1/revisions", status: http.StatusOK, contains: []string{`"total_records": 3`}},
+	})
+
+	// Two posts of 1500 different lines are too far apart to compare.
+	ts.run(t, []routeTest{
+		{name: "edit to long post", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"content":"` + strings.Repeat(`a\n`, 1499) + `a"}`, status: http.StatusOK},
+		{name: "edit to other long post", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"content":"` + strings.Repeat(`b\n`, 1499) + `b"}`, status: http.StatusOK},
+		{name: "diff too large", method: http.MethodGet, path: "/v1/posts/1/revisions/7/diff", status: http.StatusUnprocessableEntity, contains: []string{"differ in too many lines"}},
+		{name: "diff long post with short one", method: http.MethodGet, path: "/v1/posts/1/revisions/6/diff", status: http.StatusOK, contains: []string{"-The first post", "+a"}},
+	})
+}
+
+func TestCommentRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "list", method: http.MethodGet, path: "/v1/posts/1/comments", status: http.StatusOK, contains: []string{"Nice post", "Thanks"}},
+		{name: "list tree", method: http.MethodGet, path: "/v1/posts/1/comments?format=tree", status: http.StatusOK, contains: []string{"replies", "Thanks", `"total_records": 1`}},
+		{name: "list rejects unknown format", method: http.MethodGet, path: "/v1/posts/1/comments?format=nested", status: http.StatusUnprocessableEntity, contains: []string{"must be flat or tree"}},
+		{name: "list rejects bad filters", method: http.MethodGet, path: "/v1/posts/1/comments?page=-1&sort=content", status: http.StatusUnprocessableEntity, contains: []string{"must be greater than zero", "invalid sort value"}},
+		{name: "list draft anonymous", method: http.MethodGet, path: "/v1/posts/2/comments", status: http.StatusNotFound},
+		{name: "list missing post", method: http.MethodGet, path: "/v1/posts/99/comments", status: http.StatusNotFound},
+
+		{name: "show", method: http.MethodGet, path: "/v1/posts/1/comments/1", status: http.StatusOK, contains: []string{"Nice post"}},
+		{name: "show on other post", method: http.MethodGet, path: "/v1/posts/3/comments/1", status: http.StatusNotFound},
+		{name: "show missing", method: http.MethodGet, path: "/v1/posts/1/comments/99", status: http.StatusNotFound},
+
+		{name: "create anonymous", method: http.MethodPost, path: "/v1/posts/1/comments", body: `{"content":"hi"}`, status: http.StatusUnauthorized, contains: []string{msgAuthRequired}},
+		{name: "create inactive", method: http.MethodPost, path: "/v1/posts/1/comments", user: "inactive", body: `{"content":"hi"}`, status: http.StatusForbidden, contains: []string{msgInactive}},
+		{name: "create empty", method: http.MethodPost, path: "/v1/posts/1/comments", user: "reader", body: `{"content":""}`, status: http.StatusUnprocessableEntity, contains: []string{`"content": "must be provided"`}},
+		{name: "create reply to other post", method: http.MethodPost, path: "/v1/posts/3/comments", user: "reader", body: `{"content":"hi","parent_comment_id":1}`, status: http.StatusUnprocessableEntity, contains: []string{"must be a comment on the same post"}},
+		{name: "create", method: http.MethodPost, path: "/v1/posts/1/comments", user: "reader", body: `{"content":"Great read"}`, status: http.StatusOK, contains: []string{"Great read", `"id": 3`}},
+		{name: "create reply", method: http.MethodPost, path: "/v1/posts/1/comments", user: "reader", body: `{"content":"Agreed","parent_comment_id":2}`, status: http.StatusOK, contains: []string{`"depth": 2`}},
+		{name: "create on draft not owner", method: http.MethodPost, path: "/v1/posts/2/comments", user: "bob", body: `{"content":"hi"}`, status: http.StatusNotFound},
+		{name: "create on draft", method: http.MethodPost, path: "/v1/posts/2/comments", user: "alice", body: `{"content":"Note to self"}`, status: http.StatusOK, contains: []string{`"id": 5`}},
+		{name: "show on draft anonymous", method: http.MethodGet, path: "/v1/posts/2/comments/5", status: http.StatusNotFound},
+		{name: "show on draft not owner", method: http.MethodGet, path: "/v1/posts/2/comments/5", user: "bob", status: http.StatusNotFound},
+		{name: "show on draft", method: http.MethodGet, path: "/v1/posts/2/comments/5", user: "alice", status: http.StatusOK, contains: []string{"Note to self"}},
+		{name: "list for user hides drafts", method: http.MethodGet, path: "/v1/users/1/comments", user: "bob", status: http.StatusOK, contains: []string{"Thanks"}, excludes: []string{"Note to self"}},
+		{name: "update on draft not owner", method: http.MethodPatch, path: "/v1/posts/2/comments/5", user: "bob", body: `{"content":"x"}`, status: http.StatusNotFound},
+		{name: "list for user shows own drafts", method: http.MethodGet, path: "/v1/users/1/comments", user: "alice", status: http.StatusOK, contains: []string{"Thanks", "Note to self"}},
+
+		{name: "update not owner", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "alice", body: `{"content":"x"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "update empty", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "bob", body: `{"content":""}`, status: http.StatusUnprocessableEntity, contains: []string{"must be provided"}},
+		{name: "update", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "bob", body: `{"content":"Very nice post"}`, status: http.StatusOK, contains: []string{"Very nice post"}},
+		{name: "update as moderator", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "mod", body: `{"content":"Moderated"}`, status: http.StatusOK, contains: []string{"Moderated"}},
+		{name: "update missing", method: http.MethodPatch, path: "/v1/posts/1/comments/99", user: "bob", body: `{"content":"x"}`, status: http.StatusNotFound},
+
+		{name: "delete anonymous", method: http.MethodDelete, path: "/v1/posts/1/comments/1", status: http.StatusUnauthorized},
+		{name: "delete not owner", method: http.MethodDelete, path: "/v1/posts/1/comments/1", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "delete", method: http.MethodDelete, path: "/v1/posts/1/comments/1", user: "bob", status: http.StatusOK},
+		{name: "deleted with replies is a placeholder", method: http.MethodGet, path: "/v1/posts/1/comments/1", status: http.StatusOK, contains: []string{`"deleted": true`}, excludes: []string{"Moderated"}},
+		{name: "update deleted", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "bob", body: `{"content":"x"}`, status: http.StatusNotFound},
+		{name: "reply to placeholder", method: http.MethodPost, path: "/v1/posts/1/comments", user: "reader", body: `{"content":"What was it?","parent_comment_id":1}`, status: http.StatusUnprocessableEntity, contains: []string{"must not be a deleted comment"}},
+		{name: "restore not owner", method: http.MethodPost, path: "/v1/posts/1/comments/1/restore", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "restore", method: http.MethodPost, path: "/v1/posts/1/comments/1/restore", user: "bob", status: http.StatusOK, contains: []string{"Moderated"}},
+		{name: "restore not deleted", method: http.MethodPost, path: "/v1/posts/1/comments/1/restore", user: "bob", status: http.StatusNotFound},
+		{name: "delete as moderator", method: http.MethodDelete, path: "/v1/posts/1/comments/3", user: "mod", status: http.StatusOK},
+		{name: "restore as moderator", method: http.MethodPost, path: "/v1/posts/1/comments/3/restore", user: "mod", status: http.StatusOK, contains: []string{"Great read"}},
+
+		{name: "list for user", method: http.MethodGet, path: "/v1/users/5/comments", status: http.StatusOK, contains: []string{"Great read", "Agreed"}, excludes: []string{"Thanks"}},
+		{name: "list for user rejects bad filters", method: http.MethodGet, path: "/v1/users/5/comments?sort=id", status: http.StatusUnprocessableEntity, contains: []string{"invalid sort value"}},
+	})
+}
+
+func TestTagRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "list", method: http.MethodGet, path: "/v1/tags", status: http.StatusOK, contains: []string{"golang", "postgres"}},
+		{name: "list by name", method: http.MethodGet, path: "/v1/tags?name=golang", status: http.StatusOK, contains: []string{"golang"}, excludes: []string{"postgres"}},
+		{name: "list rejects bad filters", method: http.MethodGet, path: "/v1/tags?sort=-created", status: http.StatusUnprocessableEntity, contains: []string{"invalid sort value"}},
+		{name: "show", method: http.MethodGet, path: "/v1/tags/1", status: http.StatusOK, contains: []string{"golang"}},
+		{name: "show missing", method: http.MethodGet, path: "/v1/tags/99", status: http.StatusNotFound},
+
+		{name: "create anonymous", method: http.MethodPost, path: "/v1/tags", body: `{"name":"rust"}`, status: http.StatusUnauthorized},
+		{name: "create without permission", method: http.MethodPost, path: "/v1/tags", user: "alice", body: `{"name":"rust"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "create empty", method: http.MethodPost, path: "/v1/tags", user: "mod", body: `{"name":""}`, status: http.StatusUnprocessableEntity, contains: []string{"must be provided"}},
+		{name: "create duplicate", method: http.MethodPost, path: "/v1/tags", user: "mod", body: `{"name":"golang"}`, status: http.StatusUnprocessableEntity, contains: []string{"a tag with this name already exists"}},
+		{name: "create", method: http.MethodPost, path: "/v1/tags", user: "mod", body: `{"name":"rust"}`, status: http.StatusCreated, contains: []string{"rust", `"id": 3`}},
+
+		{name: "update without permission", method: http.MethodPatch, path: "/v1/tags/3", user: "alice", body: `{"name":"rustlang"}`, status: http.StatusForbidden},
+		{name: "update duplicate", method: http.MethodPatch, path: "/v1/tags/3", user: "mod", body: `{"name":"postgres"}`, status: http.StatusUnprocessableEntity, contains: []string{"a tag with this name already exists"}},
+		{name: "update", method: http.MethodPatch, path: "/v1/tags/3", user: "admin", body: `{"name":"rustlang"}`, status: http.StatusOK, contains: []string{"rustlang"}},
+		{name: "update missing", method: http.MethodPatch, path: "/v1/tags/99", user: "mod", body: `{"name":"x"}`, status: http.StatusNotFound},
+
+		{name: "delete without permission", method: http.MethodDelete, path: "/v1/tags/3", user: "alice", status: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, path: "/v1/tags/3", user: "mod", status: http.StatusOK},
+		{name: "delete twice", method: http.MethodDelete, path: "/v1/tags/3", user: "mod", status: http.StatusNotFound},
+	})
+}
+
+func TestPostTagRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "list", method: http.MethodGet, path: "/v1/posts/1/tags", status: http.StatusOK, contains: []string{"golang"}, excludes: []string{"postgres"}},
+		{name: "list draft anonymous", method: http.MethodGet, path: "/v1/posts/2/tags", status: http.StatusNotFound},
+		{name: "list rejects bad filters", method: http.MethodGet, path: "/v1/posts/1/tags?page=x", status: http.StatusUnprocessableEntity, contains: []string{"must be an integer value"}},
+
+		{name: "add anonymous", method: http.MethodPost, path: "/v1/posts/1/tags/2", status: http.StatusUnauthorized},
+		{name: "add not owner", method: http.MethodPost, path: "/v1/posts/1/tags/2", user: "bob", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "add missing tag", method: http.MethodPost, path: "/v1/posts/1/tags/99", user: "alice", status: http.StatusNotFound},
+		{name: "add missing post", method: http.MethodPost, path: "/v1/posts/99/tags/2", user: "alice", status: http.StatusNotFound},
+		{name: "add", method: http.MethodPost, path: "/v1/posts/1/tags/2", user: "alice", status: http.StatusOK},
+		{name: "add twice", method: http.MethodPost, path: "/v1/posts/1/tags/2", user: "alice", status: http.StatusConflict},
+		{name: "list after add", method: http.MethodGet, path: "/v1/posts/1/tags", status: http.StatusOK, contains: []string{"golang", "postgres"}},
+		{name: "add as moderator", method: http.MethodPost, path: "/v1/posts/3/tags/2", user: "mod", status: http.StatusOK},
+
+		{name: "remove not owner", method: http.MethodDelete, path: "/v1/posts/1/tags/2", user: "bob", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "remove", method: http.MethodDelete, path: "/v1/posts/1/tags/2", user: "alice", status: http.StatusOK},
+		{name: "remove twice", method: http.MethodDelete, path: "/v1/posts/1/tags/2", user: "alice", status: http.StatusNotFound},
+	})
+}
+
+func TestUserRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "list", method: http.MethodGet, path: "/v1/users", status: http.StatusOK, contains: []string{"alice", "inactive", `"total_records": 6`}},
+		{name: "list rejects bad filters", method: http.MethodGet, path: "/v1/users?page_size=0", status: http.StatusUnprocessableEntity, contains: []string{"must be greater than zero"}},
+		{name: "show", method: http.MethodGet, path: "/v1/users/1", status: http.StatusOK, contains: []string{"alice"}},
+		{name: "show missing", method: http.MethodGet, path: "/v1/users/99", status: http.StatusNotFound},
+
+		{name: "register invalid", method: http.MethodPost, path: "/v1/users", body: `{"username":"","email":"nope","password":"short"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be a valid email address", "must be at least 8 bytes long", `"username": "must be provided"`}},
+		{name: "register duplicate email", method: http.MethodPost, path: "/v1/users", body: `{"username":"carol","email":"alice@example.com","password":"pa55word1234"}`, status: http.StatusUnprocessableEntity, contains: []string{"a user with this email address already exists"}},
+		{name: "register duplicate username", method: http.MethodPost, path: "/v1/users", body: `{"username":"alice","email":"carol@example.com","password":"pa55word1234"}`, status: http.StatusUnprocessableEntity, contains: []string{"a user with this username already exists"}},
+		{name: "register", method: http.MethodPost, path: "/v1/users", body: `{"username":"carol","email":"carol@example.com","password":"pa55word1234"}`, status: http.StatusCreated, contains: []string{"carol", `"activated": false`}},
+		{name: "activate invalid token", method: http.MethodPut, path: "/v1/users/activated", body: `{"token":"short"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be 26 bytes long"}},
+		{name: "activate unknown token", method: http.MethodPut, path: "/v1/users/activated", body: `{"token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, status: http.StatusUnprocessableEntity, contains: []string{"invalid or expired activation token"}},
+	})
+
+	token := ts.mailedToken(t, "carol@example.com")
+
+	ts.run(t, []routeTest{
+		{name: "activate", method: http.MethodPut, path: "/v1/users/activated", body: fmt.Sprintf(`{"token":%q}`, token), status: http.StatusOK, contains: []string{`"activated": true`}},
+		{name: "activate twice", method: http.MethodPut, path: "/v1/users/activated", body: fmt.Sprintf(`{"token":%q}`, token), status: http.StatusUnprocessableEntity},
+
+		{name: "update anonymous", method: http.MethodPatch, path: "/v1/users/1", body: `{"password":"newpa55word"}`, status: http.StatusUnauthorized},
+		{name: "update inactive", method: http.MethodPatch, path: "/v1/users/6", user: "inactive", body: `{"password":"newpa55word"}`, status: http.StatusForbidden, contains: []string{msgInactive}},
+		{name: "update other user", method: http.MethodPatch, path: "/v1/users/1", user: "bob", body: `{"password":"newpa55word"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "update short password", method: http.MethodPatch, path: "/v1/users/1", user: "alice", body: `{"password":"short"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be at least 8 bytes long"}},
+		{name: "update", method: http.MethodPatch, path: "/v1/users/1", user: "alice", body: `{"password":"newpa55word"}`, status: http.StatusOK},
+
+		{name: "set role without permission", method: http.MethodPatch, path: "/v1/users/2/role", user: "mod", body: `{"role":"admin"}`, status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "set unknown role", method: http.MethodPatch, path: "/v1/users/2/role", user: "admin", body: `{"role":"owner"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be one of admin, moderator, author or reader"}},
+		{name: "set role missing user", method: http.MethodPatch, path: "/v1/users/99/role", user: "admin", body: `{"role":"reader"}`, status: http.StatusNotFound},
+		{name: "set role", method: http.MethodPatch, path: "/v1/users/2/role", user: "admin", body: `{"role":"moderator"}`, status: http.StatusOK, contains: []string{`"role": "moderator"`}},
+		{name: "demote last admin", method: http.MethodPatch, path: "/v1/users/4/role", user: "admin", body: `{"role":"reader"}`, status: http.StatusUnprocessableEntity, contains: []string{"must leave at least one active admin"}},
+		{name: "promote second admin", method: http.MethodPatch, path: "/v1/users/3/role", user: "admin", body: `{"role":"admin"}`, status: http.StatusOK, contains: []string{`"role": "admin"`}},
+		{name: "demote other admin", method: http.MethodPatch, path: "/v1/users/3/role", user: "admin", body: `{"role":"moderator"}`, status: http.StatusOK, contains: []string{`"role": "moderator"`}},
+		{name: "role applies immediately", method: http.MethodPost, path: "/v1/tags", user: "bob", body: `{"name":"rust"}`, status: http.StatusCreated},
+
+		{name: "delete other user", method: http.MethodDelete, path: "/v1/users/2", user: "alice", status: http.StatusForbidden, contains: []string{msgNotPermitted}},
+		{name: "delete", method: http.MethodDelete, path: "/v1/users/2", user: "bob", status: http.StatusOK},
+		{name: "deleted is hidden", method: http.MethodGet, path: "/v1/users/2", status: http.StatusNotFound},
+		{name: "deleted user's posts are hidden", method: http.MethodGet, path: "/v1/posts/3", status: http.StatusNotFound},
+		{name: "deleted user's token is revoked", method: http.MethodGet, path: "/v1/healthcheck", user: "bob", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+		{name: "restore without permission", method: http.MethodPost, path: "/v1/users/2/restore", user: "mod", status: http.StatusForbidden},
+		{name: "restore", method: http.MethodPost, path: "/v1/users/2/restore", user: "admin", status: http.StatusOK, contains: []string{"bob"}},
+		{name: "restore not deleted", method: http.MethodPost, path: "/v1/users/2/restore", user: "admin", status: http.StatusNotFound},
+		{name: "restored user's posts are back", method: http.MethodGet, path: "/v1/posts/3", status: http.StatusOK},
+	})
+}
+
+// Changing the password keeps the session used to change it, and revokes the
+// user's other sessions.
+func TestPasswordChange(t *testing.T) {
+	ts := newTestServer(t)
+
+	access, refresh, err := ts.app.models.Tokens.NewPair(context.Background(), 1, time.Hour, time.Hour, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.tokens["alice-phone"] = access.Plaintext
+
+	ts.run(t, []routeTest{
+		{name: "update", method: http.MethodPatch, path: "/v1/users/1", user: "alice", body: `{"password":"newpa55word"}`, status: http.StatusOK},
+		{name: "session kept", method: http.MethodGet, path: "/v1/tokens/authentication", user: "alice", status: http.StatusOK},
+		{name: "session's refresh token kept", method: http.MethodPost, path: "/v1/tokens/refresh", body: fmt.Sprintf(`{"refresh_token":%q}`, ts.refreshTokens["alice"]), status: http.StatusCreated},
+		{name: "other session revoked", method: http.MethodGet, path: "/v1/tokens/authentication", user: "alice-phone", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+		{name: "other refresh token revoked", method: http.MethodPost, path: "/v1/tokens/refresh", body: fmt.Sprintf(`{"refresh_token":%q}`, refresh.Plaintext), status: http.StatusUnauthorized},
+		{name: "other users keep their sessions", method: http.MethodGet, path: "/v1/tokens/authentication", user: "bob", status: http.StatusOK},
+	})
+}
+
+func TestTokenRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "login invalid", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"nope","password":""}`, status: http.StatusUnprocessableEntity, contains: []string{"must be a valid email address", `"password": "must be provided"`}},
+		{name: "login unknown email", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"nobody@example.com","password":"pa55word1234"}`, status: http.StatusUnauthorized, contains: []string{"invalid authentication credentials"}},
+		{name: "login wrong password", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"alice@example.com","password":"wrongpa55word"}`, status: http.StatusUnauthorized, contains: []string{"invalid authentication credentials"}},
+		{name: "login", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"alice@example.com","password":"pa55word1234"}`, status: http.StatusCreated, contains: []string{"authentication_token", "refresh_token"}},
+
+		{name: "sessions anonymous", method: http.MethodGet, path: "/v1/tokens/authentication", status: http.StatusUnauthorized, contains: []string{msgAuthRequired}},
+		{name: "sessions", method: http.MethodGet, path: "/v1/tokens/authentication", user: "alice", status: http.StatusOK, contains: []string{`"current": true`, `"current": false`}},
+
+		{name: "refresh invalid", method: http.MethodPost, path: "/v1/tokens/refresh", body: `{"refresh_token":"short"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be 26 bytes long"}},
+		{name: "refresh unknown", method: http.MethodPost, path: "/v1/tokens/refresh", body: `{"refresh_token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, status: http.StatusUnauthorized, contains: []string{"invalid or expired refresh token"}},
+		{name: "refresh", method: http.MethodPost, path: "/v1/tokens/refresh", body: fmt.Sprintf(`{"refresh_token":%q}`, ts.refreshTokens["bob"]), status: http.StatusCreated, contains: []string{"authentication_token", "refresh_token"}},
+		{name: "refresh reused", method: http.MethodPost, path: "/v1/tokens/refresh", body: fmt.Sprintf(`{"refresh_token":%q}`, ts.refreshTokens["bob"]), status: http.StatusUnauthorized, contains: []string{"invalid or expired refresh token"}},
+		{name: "reuse revokes the family", method: http.MethodGet, path: "/v1/healthcheck", user: "bob", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+
+		{name: "logout anonymous", method: http.MethodDelete, path: "/v1/tokens/authentication", status: http.StatusUnauthorized},
+		{name: "logout", method: http.MethodDelete, path: "/v1/tokens/authentication", user: "mod", status: http.StatusOK},
+		{name: "logged out token is rejected", method: http.MethodGet, path: "/v1/tokens/authentication", user: "mod", status: http.StatusUnauthorized, contains: []string{msgInvalidToken}},
+
+		{name: "logout everywhere anonymous", method: http.MethodDelete, path: "/v1/tokens/authentication/all", status: http.StatusUnauthorized},
+		{name: "logout everywhere", method: http.MethodDelete, path: "/v1/tokens/authentication/all", user: "alice", status: http.StatusOK},
+		{name: "all tokens are rejected", method: http.MethodGet, path: "/v1/tokens/authentication", user: "alice", status: http.StatusUnauthorized},
+		{name: "refresh after logout everywhere", method: http.MethodPost, path: "/v1/tokens/refresh", body: fmt.Sprintf(`{"refresh_token":%q}`, ts.refreshTokens["alice"]), status: http.StatusUnauthorized},
+
+		{name: "password reset invalid email", method: http.MethodPost, path: "/v1/tokens/password-reset", body: `{"email":"nope"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be a valid email address"}},
+		{name: "password reset unknown email", method: http.MethodPost, path: "/v1/tokens/password-reset", body: `{"email":"nobody@example.com"}`, status: http.StatusAccepted},
+		{name: "password reset", method: http.MethodPost, path: "/v1/tokens/password-reset", body: `{"email":"reader@example.com"}`, status: http.StatusAccepted},
+		{name: "reset invalid", method: http.MethodPut, path: "/v1/users/password", body: `{"password":"short","token":"short"}`, status: http.StatusUnprocessableEntity, contains: []string{"must be at least 8 bytes long", "must be 26 bytes long"}},
+		{name: "reset unknown token", method: http.MethodPut, path: "/v1/users/password", body: `{"password":"newpa55word","token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, status: http.StatusUnprocessableEntity, contains: []string{"invalid or expired password reset token"}},
+	})
+
+	token := ts.mailedToken(t, "reader@example.com")
+
+	ts.run(t, []routeTest{
+		{name: "reset", method: http.MethodPut, path: "/v1/users/password", body: fmt.Sprintf(`{"password":"newpa55word","token":%q}`, token), status: http.StatusOK},
+		{name: "reset twice", method: http.MethodPut, path: "/v1/users/password", body: fmt.Sprintf(`{"password":"newpa55word","token":%q}`, token), status: http.StatusUnprocessableEntity},
+		{name: "old password is rejected", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"reader@example.com","password":"pa55word1234"}`, status: http.StatusUnauthorized},
+		{name: "new password is accepted", method: http.MethodPost, path: "/v1/tokens/authentication", body: `{"email":"reader@example.com","password":"newpa55word"}`, status: http.StatusCreated},
+	})
+}
+
+func TestTrashRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.run(t, []routeTest{
+		{name: "anonymous", method: http.MethodGet, path: "/v1/trash", status: http.StatusUnauthorized, contains: []string{msgAuthRequired}},
+		{name: "empty", method: http.MethodGet, path: "/v1/trash", user: "alice", status: http.StatusOK, contains: []string{`"retention": "720h0m0s"`}},
+		{name: "delete post", method: http.MethodDelete, path: "/v1/posts/1", user: "alice", status: http.StatusOK},
+		{name: "delete comment", method: http.MethodDelete, path: "/v1/posts/3/comments/1", user: "bob", status: http.StatusNotFound},
+		{name: "comment on deleted post", method: http.MethodPost, path: "/v1/posts/1/comments", user: "bob", body: `{"content":"hi"}`, status: http.StatusNotFound},
+		{name: "comment on own deleted post", method: http.MethodPost, path: "/v1/posts/1/comments", user: "alice", body: `{"content":"hi"}`, status: http.StatusNotFound},
+		{name: "show comment on deleted post", method: http.MethodGet, path: "/v1/posts/1/comments/1", user: "alice", status: http.StatusNotFound},
+		{name: "update comment on deleted post", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "bob", body: `{"content":"x"}`, status: http.StatusNotFound},
+		{name: "restore comment on deleted post", method: http.MethodPost, path: "/v1/posts/1/comments/1/restore", user: "bob", status: http.StatusNotFound},
+		{name: "restore comment on deleted post as moderator", method: http.MethodPost, path: "/v1/posts/1/comments/1/restore", user: "mod", status: http.StatusNotFound},
+		{name: "posts", method: http.MethodGet, path: "/v1/trash", user: "alice", status: http.StatusOK, contains: []string{"Hello world"}},
+		{name: "posts of other users", method: http.MethodGet, path: "/v1/trash", user: "bob", status: http.StatusOK, excludes: []string{"Hello world"}},
+		{name: "comments deleted with the post", method: http.MethodGet, path: "/v1/trash?type=comments", user: "bob", status: http.StatusOK, contains: []string{"Nice post"}},
+		{name: "restore post", method: http.MethodPost, path: "/v1/posts/1/restore", user: "alice", status: http.StatusOK},
+		{name: "comments restored with the post", method: http.MethodGet, path: "/v1/posts/1/comments/1", status: http.StatusOK, contains: []string{"Nice post"}},
+		{name: "unknown type", method: http.MethodGet, path: "/v1/trash?type=users", user: "alice", status: http.StatusUnprocessableEntity, contains: []string{"must be posts or comments"}},
+		{name: "bad filters", method: http.MethodGet, path: "/v1/trash?sort=title", user: "alice", status: http.StatusUnprocessableEntity, contains: []string{"invalid sort value"}},
+	})
+}
+
+type listResponse struct {
+	Posts    []data.Post   `json:"posts"`
+	Metadata data.Metadata `json:"metadata"`
+}
+
+func (ts *testServer) listPosts(t *testing.T, query url.Values) listResponse {
+	t.Helper()
+
+	status, _, body := ts.do(t, http.MethodGet, "/v1/posts?"+query.Encode(), "", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want 200; body: %s", status, body)
+	}
+
+	var res listResponse
+
+	err := json.Unmarshal([]byte(body), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func postIDs(posts []data.Post) []int64 {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+func TestPagination(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Posts 4 to 8 join the two published posts of the fixture, so there are
+	// seven posts visible to anonymous users.
+	for i := 4; i <= 8; i++ {
+		ts.run(t, []routeTest{
+			{name: fmt.Sprintf("create post %d", i), method: http.MethodPost, path: "/v1/posts", user: "alice", body: fmt.Sprintf(`{"title":"Post %d","content":"Words"}`, i), status: http.StatusCreated},
+		})
+	}
+
+	t.Run("page metadata", func(t *testing.T) {
+		res := ts.listPosts(t, url.Values{"page": {"2"}, "page_size": {"3"}, "sort": {"-id"}})
+
+		want := data.Metadata{CurrentPage: 2, PageSize: 3, FirstPage: 1, LastPage: 3, TotalRecords: 7}
+		got := res.Metadata
+		got.NextCursor, got.PrevCursor = "", ""
+
+		if got != want {
+			t.Errorf("got metadata %+v; want %+v", got, want)
+		}
+
+		if ids := postIDs(res.Posts); fmt.Sprint(ids) != "[5 4 3]" {
+			t.Errorf("got posts %v; want [5 4 3]", ids)
+		}
+
+		if res.Metadata.NextCursor == "" || res.Metadata.PrevCursor == "" {
+			t.Errorf("got metadata %+v; want next and previous cursors", res.Metadata)
+		}
+	})
+
+	// The total is counted alongside the rows, so a page past the end has
+	// no metadata at all.
+	t.Run("page past the end", func(t *testing.T) {
+		res := ts.listPosts(t, url.Values{"page": {"10"}, "page_size": {"3"}})
+
+		if len(res.Posts) != 0 || res.Metadata != (data.Metadata{}) {
+			t.Errorf("got %d posts and metadata %+v; want no posts and empty metadata", len(res.Posts), res.Metadata)
+		}
+	})
+
+	t.Run("cursors", func(t *testing.T) {
+		query := url.Values{"page_size": {"3"}, "sort": {"-id"}}
+
+		var pages [][]int64
+
+		for {
+			res := ts.listPosts(t, query)
+			pages = append(pages, postIDs(res.Posts))
+
+			if len(pages) > 1 && res.Metadata.TotalRecords != 0 {
+				t.Errorf("got total_records %d on a cursor page; want it skipped", res.Metadata.TotalRecords)
+			}
+
+			if res.Metadata.NextCursor == "" {
+				break
+			}
+
+			query.Set("after", res.Metadata.NextCursor)
+		}
+
+		if fmt.Sprint(pages) != "[[8 7 6] [5 4 3] [1]]" {
+			t.Fatalf("got pages %v; want [[8 7 6] [5 4 3] [1]]", pages)
+		}
+
+		res := ts.listPosts(t, url.Values{"page_size": {"3"}, "sort": {"-id"}, "after": {query.Get("after")}})
+
+		query = url.Values{"page_size": {"3"}, "sort": {"-id"}, "before": {res.Metadata.PrevCursor}}
+
+		if ids := postIDs(ts.listPosts(t, query).Posts); fmt.Sprint(ids) != "[5 4 3]" {
+			t.Errorf("got posts %v before the last page; want [5 4 3]", ids)
+		}
+	})
+
+	t.Run("count=false", func(t *testing.T) {
+		res := ts.listPosts(t, url.Values{"page_size": {"3"}, "count": {"false"}})
+
+		if res.Metadata.TotalRecords != 0 || res.Metadata.LastPage != 0 || res.Metadata.NextCursor == "" {
+			t.Errorf("got metadata %+v; want no totals but a next cursor", res.Metadata)
+		}
+	})
+
+	cursor := ts.listPosts(t, url.Values{"page_size": {"3"}}).Metadata.NextCursor
+
+	ts.run(t, []routeTest{
+		{name: "count must be a boolean", method: http.MethodGet, path: "/v1/posts?count=maybe", status: http.StatusUnprocessableEntity, contains: []string{"must be a boolean value"}},
+		{name: "after and before together", method: http.MethodGet, path: "/v1/posts?after=" + cursor + "&before=" + cursor, status: http.StatusUnprocessableEntity, contains: []string{"must not be used together with after"}},
+		{name: "cursor for another sort", method: http.MethodGet, path: "/v1/posts?sort=-id&after=" + cursor, status: http.StatusUnprocessableEntity, contains: []string{msgInvalidCursor}},
+		{name: "tampered cursor", method: http.MethodGet, path: "/v1/posts?after=x" + cursor[1:], status: http.StatusUnprocessableEntity, contains: []string{msgInvalidCursor}},
+	})
+}
+
+// conflictingPosts simulates another client saving a post between the
+// handler reading it and writing it back.
+type conflictingPosts struct {
+	data.PostStore
+}
+
+func (s conflictingPosts) Update(ctx context.Context, post *data.Post) error {
+	current, err := s.PostStore.Get(ctx, post.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.PostStore.Update(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	return s.PostStore.Update(ctx, post)
+}
+
+type conflictingComments struct {
+	data.CommentStore
+}
+
+func (s conflictingComments) Update(ctx context.Context, comment *data.Comment) error {
+	current, err := s.CommentStore.Get(ctx, comment.PostID, comment.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.CommentStore.Update(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	return s.CommentStore.Update(ctx, comment)
+}
+
+type conflictingUsers struct {
+	data.UserStore
+}
+
+func (s conflictingUsers) Update(ctx context.Context, user *data.User) error {
+	current, err := s.UserStore.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.UserStore.Update(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	return s.UserStore.Update(ctx, user)
+}
+
+type conflictingTags struct {
+	data.TagStore
+}
+
+func (s conflictingTags) Update(ctx context.Context, tag *data.Tag) error {
+	current, err := s.TagStore.Get(ctx, tag.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.TagStore.Update(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	return s.TagStore.Update(ctx, tag)
+}
+
+func TestEditConflicts(t *testing.T) {
+	ts := newTestServer(t)
+
+	models := &ts.app.models
+	models.Posts = conflictingPosts{models.Posts}
+	models.Comments = conflictingComments{models.Comments}
+	models.Users = conflictingUsers{models.Users}
+	models.Tags = conflictingTags{models.Tags}
+
+	ts.run(t, []routeTest{
+		{name: "update post", method: http.MethodPatch, path: "/v1/posts/1", user: "alice", body: `{"title":"x"}`, status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "publish post", method: http.MethodPost, path: "/v1/posts/2/publish", user: "alice", status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "restore revision", method: http.MethodPost, path: "/v1/posts/1/revisions/1/restore", user: "alice", status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "update comment", method: http.MethodPatch, path: "/v1/posts/1/comments/1", user: "bob", body: `{"content":"x"}`, status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "update user", method: http.MethodPatch, path: "/v1/users/1", user: "alice", body: `{"password":"newpa55word"}`, status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "set role", method: http.MethodPatch, path: "/v1/users/2/role", user: "admin", body: `{"role":"reader"}`, status: http.StatusConflict, contains: []string{msgEditConflict}},
+		{name: "update tag", method: http.MethodPatch, path: "/v1/tags/1", user: "mod", body: `{"name":"go"}`, status: http.StatusConflict, contains: []string{msgEditConflict}},
+	})
+}
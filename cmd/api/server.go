@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,8 +23,45 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	// The metrics listener is opened before anything else is started, so a
+	// bad -metrics-addr stops the server from starting at all.
+	var metricsSrv *http.Server
+
+	if app.config.metrics.addr != "" {
+		ln, err := net.Listen("tcp", app.config.metrics.addr)
+		if err != nil {
+			return err
+		}
+
+		metricsSrv = &http.Server{
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+
+		go func() {
+			err := metricsSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error(err.Error(), "addr", ln.Addr().String())
+			}
+		}()
+
+		app.logger.Info("serving metrics", "addr", ln.Addr().String())
+	}
+
 	shutdownError := make(chan error)
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	app.startScheduledPublisher(jobsCtx)
+	app.startTrashPurger(jobsCtx)
+	app.startRateLimiterCleanup(jobsCtx)
+	app.startLoginFailureCleanup(jobsCtx)
+	app.startTokenJanitor(jobsCtx)
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -31,13 +69,40 @@ func (app *application) serve() error {
 
 		app.logger.Info("shutting down server", "signal", s.String())
 
+		// Fail readiness checks first and keep serving for a while, so load
+		// balancers stop sending requests before the listener closes.
+		app.shuttingDown.Store(true)
+
+		if delay := app.config.shutdown.drainDelay; delay > 0 {
+			app.logger.Info("draining requests", "delay", delay.String())
+			time.Sleep(delay)
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			err := metricsSrv.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		stopJobs()
+
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+
+		app.wg.Wait()
+		shutdownError <- nil
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "version", build.Version, "commit", build.Commit)
 
 	err := srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
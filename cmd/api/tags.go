@@ -26,13 +26,16 @@ func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "name", "updated_at", "-id", "-name", "-updated_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	tags, metadata, err := app.models.Tags.GetAll(0, input.Name, input.Filters)
+	tags, metadata, err := app.models.Tags.GetAll(r.Context(), 0, input.Name, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -51,7 +54,7 @@ func (app *application) showTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tag, err := app.models.Tags.Get(id)
+	tag, err := app.models.Tags.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -79,9 +82,6 @@ func (app *application) createTagHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// TODO: hacer algo con esto
-	// currentUser := app.contextGetUser(r)
-
 	tag := &data.Tag{
 		Name: input.Name,
 	}
@@ -93,7 +93,7 @@ func (app *application) createTagHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Tags.Insert(tag)
+	err = app.models.Tags.Insert(r.Context(), tag)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEntry):
@@ -121,7 +121,7 @@ func (app *application) updateTagHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	tag, err := app.models.Tags.Get(id)
+	tag, err := app.models.Tags.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -151,7 +151,7 @@ func (app *application) updateTagHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Tags.Update(tag)
+	err = app.models.Tags.Update(r.Context(), tag)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -178,13 +178,13 @@ func (app *application) deleteTagHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Tags.Delete(id)
+	err = app.models.Tags.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrUnauthorized):
-			app.invalidUserResponse(w, r)
+			app.notPermittedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -204,6 +204,22 @@ func (app *application) listPostTagsHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	post, err := app.models.Posts.Get(r.Context(), postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canViewPost(app.contextGetUser(r), post) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name string
 		data.Filters
@@ -219,13 +235,16 @@ func (app *application) listPostTagsHandler(w http.ResponseWriter, r *http.Reque
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "name", "updated_at", "-id", "-name", "-updated_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	tags, metadata, err := app.models.Tags.GetAll(postID, input.Name, input.Filters)
+	tags, metadata, err := app.models.Tags.GetAll(r.Context(), postID, input.Name, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
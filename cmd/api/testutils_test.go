@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/mailer"
+)
+
+const testPassword = "pa55word1234"
+
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.tokens.accessTTL = 15 * time.Minute
+	cfg.tokens.refreshTTL = 24 * time.Hour
+	cfg.tokens.cleanupInterval = time.Hour
+	cfg.tokens.cleanupBatchSize = 1000
+	cfg.trash.retention = 30 * 24 * time.Hour
+	cfg.login.maxFailures = 10
+	cfg.login.ipMaxFailures = 50
+	cfg.login.backoff = time.Second
+	cfg.login.lockout = 15 * time.Minute
+	cfg.healthcheck.timeout = time.Second
+
+	models := data.NewMemoryModels()
+
+	return &application{
+		config:      cfg,
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		db:          &testDB{},
+		schema:      &testSchema{version: 14, latest: 14},
+		models:      models,
+		mailer:      mailer.NewLog(nil),
+		metrics:     newMetrics(nil),
+		rateLimits:  models.RateLimits,
+		limiter:     newRateLimiter("api:", cfg.limiter.rps, cfg.limiter.burst),
+		authLimiter: newRateLimiter("auth:", cfg.limiter.authRPS, cfg.limiter.authBurst),
+	}
+}
+
+// testDB stands in for the database in the readiness check, which fails to
+// ping it while err is set.
+type testDB struct {
+	err error
+}
+
+func (db *testDB) PingContext(ctx context.Context) error {
+	return db.err
+}
+
+func (db *testDB) Stats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 25, OpenConnections: 2, Idle: 2}
+}
+
+type testSchema struct {
+	version int64
+	dirty   bool
+	latest  int64
+}
+
+func (s *testSchema) Version(ctx context.Context) (int64, bool, error) {
+	return s.version, s.dirty, nil
+}
+
+func (s *testSchema) Latest() int64 {
+	return s.latest
+}
+
+// testServer serves the application's routes over a real HTTP connection.
+// Its store is seeded with the fixture below, and each fixture user has a
+// session whose access token is kept in tokens by username.
+//
+// Users: alice (author), bob (author), mod (moderator), admin (admin),
+// reader (reader) and inactive (author, not activated), with IDs 1 to 6.
+//
+// Posts: 1 "Hello world" by alice, 2 "Secret draft" by alice (a draft) and
+// 3 "Bob on databases" by bob, each with one revision (IDs 1 to 3).
+//
+// Comments on post 1: 1 "Nice post" by bob and 2 "Thanks" by alice, a reply
+// to comment 1.
+//
+// Tags: 1 "golang" (on post 1) and 2 "postgres".
+type testServer struct {
+	*httptest.Server
+	app           *application
+	mailer        *mailer.LogMailer
+	tokens        map[string]string
+	refreshTokens map[string]string
+}
+
+// Hashing a password is deliberately slow, so every fixture user in every
+// test shares the same hash.
+var hashedUser = sync.OnceValues(func() (*data.User, error) {
+	user := &data.User{}
+	err := user.Password.Set(testPassword)
+	return user, err
+})
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	app := newTestApplication(t)
+
+	ts := &testServer{
+		Server:        httptest.NewServer(app.routes()),
+		app:           app,
+		mailer:        app.mailer.(*mailer.LogMailer),
+		tokens:        make(map[string]string),
+		refreshTokens: make(map[string]string),
+	}
+
+	t.Cleanup(ts.Close)
+
+	ts.seed(t)
+
+	return ts
+}
+
+func (ts *testServer) seed(t *testing.T) {
+	t.Helper()
+
+	ctx := context.Background()
+	models := ts.app.models
+
+	hashed, err := hashedUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []struct {
+		name      string
+		role      string
+		activated bool
+	}{
+		{"alice", data.RoleAuthor, true},
+		{"bob", data.RoleAuthor, true},
+		{"mod", data.RoleModerator, true},
+		{"admin", data.RoleAdmin, true},
+		{"reader", data.RoleReader, true},
+		{"inactive", data.RoleAuthor, false},
+	}
+
+	for _, u := range users {
+		user := &data.User{
+			Username:  u.name,
+			Email:     u.name + "@example.com",
+			Password:  hashed.Password,
+			Activated: u.activated,
+			Role:      u.role,
+		}
+
+		err := models.Users.Insert(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		access, refresh, err := models.Tokens.NewPair(ctx, user.ID, ts.app.config.tokens.accessTTL, ts.app.config.tokens.refreshTTL, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts.tokens[u.name] = access.Plaintext
+		ts.refreshTokens[u.name] = refresh.Plaintext
+	}
+
+	posts := []*data.Post{
+		{UserID: 1, Title: "Hello world", Content: "The first post", Status: data.PostStatusPublished},
+		{UserID: 1, Title: "Secret draft", Content: "Not ready yet", Status: data.PostStatusDraft},
+		{UserID: 2, Title: "Bob on databases", Content: "Indexes matter", Status: data.PostStatusPublished},
+	}
+
+	for _, post := range posts {
+		err := models.Posts.Insert(ctx, post)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parentID := int64(1)
+
+	comments := []*data.Comment{
+		{PostID: 1, UserID: 2, Content: "Nice post"},
+		{PostID: 1, UserID: 1, ParentCommentID: &parentID, Depth: 1, Content: "Thanks"},
+	}
+
+	for _, comment := range comments {
+		err := models.Comments.Insert(ctx, comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"golang", "postgres"} {
+		err := models.Tags.Insert(ctx, &data.Tag{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = models.PostTags.Insert(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends a request with an optional JSON body, authenticated as the named
+// fixture user unless user is empty, and returns the status code, headers
+// and body of the response.
+func (ts *testServer) do(t *testing.T, method, path, user, body string) (int, http.Header, string) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "" {
+		token, ok := ts.tokens[user]
+		if !ok {
+			t.Fatalf("no token for user %q", user)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, res.Header, string(resBody)
+}
+
+// routeTest describes one request and what its response must look like.
+type routeTest struct {
+	name     string
+	method   string
+	path     string
+	user     string
+	body     string
+	status   int
+	contains []string
+	excludes []string
+}
+
+// run sends the requests in order, so that later cases can rely on the
+// changes made by earlier ones.
+func (ts *testServer) run(t *testing.T, tests []routeTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := ts.do(t, tt.method, tt.path, tt.user, tt.body)
+
+			if status != tt.status {
+				t.Errorf("%s %s: got status %d; want %d; body: %s", tt.method, tt.path, status, tt.status, body)
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s %s: want body to contain %q; body: %s", tt.method, tt.path, want, body)
+				}
+			}
+
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("%s %s: want body not to contain %q; body: %s", tt.method, tt.path, unwanted, body)
+				}
+			}
+		})
+	}
+}
+
+var tokenRX = regexp.MustCompile(`[A-Z2-7]{26}`)
+
+// mailedToken waits for background emails to be sent and returns the token
+// from the last email sent to recipient.
+func (ts *testServer) mailedToken(t *testing.T, recipient string) string {
+	t.Helper()
+
+	ts.app.wg.Wait()
+
+	messages := ts.mailer.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Recipient == recipient {
+			token := tokenRX.FindString(messages[i].PlainBody)
+			if token == "" {
+				t.Fatalf("no token in email to %s: %s", recipient, messages[i].PlainBody)
+			}
+			return token
+		}
+	}
+
+	t.Fatalf("no email sent to %s", recipient)
+	return ""
+}
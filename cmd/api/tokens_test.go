@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+)
+
+// syncBuffer is a bytes.Buffer that a logger and a test can share.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTokenJanitor(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	logs := &syncBuffer{}
+	app.logger = slog.New(slog.NewTextHandler(logs, nil))
+
+	app.config.tokens.cleanupInterval = 10 * time.Millisecond
+	app.config.tokens.cleanupBatchSize = 2
+
+	hashed, err := hashedUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{Username: "alice", Email: "alice@example.com", Password: hashed.Password, Activated: true}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset, data.ScopeAuthentication} {
+		_, err := app.models.Tokens.New(ctx, user.ID, -time.Minute, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	app.startTokenJanitor(jobsCtx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "deleted expired tokens") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopJobs()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not stop after its context was cancelled")
+	}
+
+	if !strings.Contains(logs.String(), "count=5") {
+		t.Errorf("got logs %q; want 5 expired tokens deleted in one run", logs)
+	}
+
+	remaining, err := app.models.Tokens.DeleteExpired(ctx, 100)
+	if err != nil || remaining != 0 {
+		t.Errorf("got %d expired tokens left, %v; want none", remaining, err)
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(ctx, data.ScopeAuthentication, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 {
+		t.Errorf("got %d authentication tokens; want the unexpired one kept", len(tokens))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/manuelam2003/blogly/internal/data"
+	"github.com/manuelam2003/blogly/internal/validator"
+)
+
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Type = app.readString(qs, "type", "posts")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "deleted_at", "-id", "-deleted_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.SkipCount = !app.readBool(qs, "count", true, v)
+
+	v.Check(validator.PermittedValue(input.Type, "posts", "comments"), "type", "must be posts or comments")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	currentUser := app.contextGetUser(r)
+
+	var (
+		items    any
+		metadata data.Metadata
+		err      error
+	)
+
+	switch input.Type {
+	case "posts":
+		items, metadata, err = app.models.Posts.GetDeletedForUser(r.Context(), currentUser.ID, input.Filters)
+	case "comments":
+		items, metadata, err = app.models.Comments.GetDeletedByUser(r.Context(), currentUser.ID, input.Filters)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		input.Type:  items,
+		"metadata":  metadata,
+		"retention": app.config.trash.retention.String(),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) startTrashPurger(ctx context.Context) {
+	app.runPeriodically(ctx, app.config.trash.purgeInterval, func(ctx context.Context) {
+		before := time.Now().Add(-app.config.trash.retention)
+
+		purgers := []struct {
+			name  string
+			purge func(context.Context, time.Time) (int64, error)
+		}{
+			{"comments", app.models.Comments.PurgeDeleted},
+			{"posts", app.models.Posts.PurgeDeleted},
+			{"users", app.models.Users.PurgeDeleted},
+		}
+
+		for _, purger := range purgers {
+			purged, err := purger.purge(ctx, before)
+			if err != nil {
+				app.logger.Error(err.Error(), "trash", purger.name)
+				continue
+			}
+
+			if purged > 0 {
+				app.logger.Info("purged trash", "trash", purger.name, "count", purged)
+			}
+		}
+	})
+}
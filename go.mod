@@ -3,8 +3,12 @@ module github.com/manuelam2003/blogly
 go 1.22.1
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.26.0
-	golang.org/x/time v0.6.0
+	golang.org/x/term v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.23.0 // indirect
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditLoginLocked   = "login.locked"
+	AuditLoginUnlocked = "login.unlocked"
+)
+
+// AuditEntry records a security-relevant event. UserID is the account the
+// event is about and ActorID the user who caused it; either is nil when
+// there is no such user, such as for an IP address being locked out by the
+// system. Subject names what the event applied to, such as an email address
+// or an IP address.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	Subject   string    `json:"subject"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m AuditModel) Insert(ctx context.Context, entry *AuditEntry) error {
+	query := `
+        INSERT INTO audit_log (action, user_id, actor_id, subject, ip)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at`
+
+	args := []any{entry.Action, entry.UserID, entry.ActorID, entry.Subject, entry.IP}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+func (m AuditModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*AuditEntry, Metadata, error) {
+	args := []any{userID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s, %s, id, action, user_id, actor_id, subject, ip, created_at
+	FROM audit_log
+	WHERE user_id = $1
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
+
+	args = append(args, page.args...)
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var (
+			entry AuditEntry
+			key   cursorKey
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Value,
+			&entry.ID,
+			&entry.Action,
+			&entry.UserID,
+			&entry.ActorID,
+			&entry.Subject,
+			&entry.IP,
+			&entry.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = entry.ID
+		entries = append(entries, &entry)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	entries, metadata := paginate(entries, keys, filters, totalRecords)
+
+	return entries, metadata, nil
+}
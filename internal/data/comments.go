@@ -7,20 +7,34 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/manuelam2003/blogly/internal/validator"
 )
 
+const (
+	MaxCommentDepth = 5
+
+	DeletedCommentContent = "[deleted]"
+)
+
 type Comment struct {
-	ID        int64     `json:"id"`
-	PostID    int64     `json:"post_id"`
-	UserID    int64     `json:"user_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              int64      `json:"id"`
+	PostID          int64      `json:"post_id"`
+	UserID          int64      `json:"user_id"`
+	ParentCommentID *int64     `json:"parent_comment_id,omitempty"`
+	Depth           int        `json:"depth"`
+	Content         string     `json:"content"`
+	Deleted         bool       `json:"deleted,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	Replies         []*Comment `json:"replies,omitempty"`
+	CreatedAt       time.Time  `json:"-"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CommentModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
 func ValidateComment(v *validator.Validator, comment *Comment) {
@@ -29,27 +43,68 @@ func ValidateComment(v *validator.Validator, comment *Comment) {
 
 	v.Check(comment.Content != "", "content", "must be provided")
 	v.Check(len(comment.Content) <= 3000, "content", "must not be more than 3000 bytes long")
-}
 
-func (m CommentModel) Get(postID, commentID int64) (*Comment, error) {
-	query := `
-        SELECT id, post_id, user_id, content, created_at, updated_at
-        FROM comments
-        WHERE post_id = $1 AND id = $2`
+	v.Check(comment.Depth <= MaxCommentDepth, "parent_comment_id", fmt.Sprintf("replies must not be nested more than %d levels deep", MaxCommentDepth))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// Deleted comments stay visible as a "[deleted]" placeholder for as long as
+// a reply below them, direct or nested, is still live, so that threads don't
+// lose their structure. A comment's reply count covers the replies that are
+// visible. Placeholders left behind by purged users have no user, which is
+// reported as user 0.
+var (
+	commentColumns = `
+        comments.id, comments.post_id, COALESCE(comments.user_id, 0), comments.parent_comment_id, comments.depth,
+        CASE WHEN comments.deleted_at IS NULL THEN comments.content ELSE '` + DeletedCommentContent + `' END,
+        comments.deleted_at IS NOT NULL,
+        (SELECT count(*) FROM comments replies WHERE replies.parent_comment_id = comments.id
+            AND (replies.deleted_at IS NULL OR ` + hasLiveReplies("replies") + `)),
+        comments.created_at, comments.updated_at`
+
+	commentVisible = `
+        (comments.deleted_at IS NULL OR ` + hasLiveReplies("comments") + `)`
+)
 
-	var comment Comment
+// hasLiveReplies returns a condition that holds when the comment named by
+// table has a reply, direct or nested, that isn't deleted.
+func hasLiveReplies(table string) string {
+	return `EXISTS (
+            WITH RECURSIVE descendants AS (
+                SELECT d.id, d.deleted_at FROM comments d WHERE d.parent_comment_id = ` + table + `.id
+                UNION ALL
+                SELECT d.id, d.deleted_at FROM comments d
+                INNER JOIN descendants ON d.parent_comment_id = descendants.id
+            )
+            SELECT 1 FROM descendants WHERE descendants.deleted_at IS NULL)`
+}
 
-	err := m.DB.QueryRowContext(ctx, query, postID, commentID).Scan(
+func (comment *Comment) scanDest() []any {
+	return []any{
 		&comment.ID,
 		&comment.PostID,
 		&comment.UserID,
+		&comment.ParentCommentID,
+		&comment.Depth,
 		&comment.Content,
+		&comment.Deleted,
+		&comment.ReplyCount,
 		&comment.CreatedAt,
 		&comment.UpdatedAt,
-	)
+	}
+}
+
+func (m CommentModel) Get(ctx context.Context, postID, commentID int64) (*Comment, error) {
+	query := `
+        SELECT ` + commentColumns + `
+        FROM comments
+        WHERE post_id = $1 AND id = $2 AND ` + commentVisible
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var comment Comment
+
+	err := m.DB.QueryRowContext(ctx, query, postID, commentID).Scan(comment.scanDest()...)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -61,63 +116,157 @@ func (m CommentModel) Get(postID, commentID int64) (*Comment, error) {
 	return &comment, nil
 }
 
-func (c CommentModel) GetAllForPost(postID int64, filters Filters) ([]*Comment, Metadata, error) {
+// GetAllForPost lists the comments on a post. With rootsOnly set only
+// top-level comments are returned, for building threads with GetReplies.
+func (c CommentModel) GetAllForPost(ctx context.Context, postID int64, rootsOnly bool, filters Filters) ([]*Comment, Metadata, error) {
+	args := []any{postID, rootsOnly}
+
+	page, err := filters.pageQuery("comments.", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id, post_id, user_id, content, created_at, updated_at
+        SELECT %s, %s, %s
         FROM comments
         WHERE post_id = $1
-        ORDER BY %s %s
-        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+        AND (parent_comment_id IS NULL OR NOT $2)
+        AND %s
+        AND %s
+        ORDER BY %s
+        %s`, page.count, page.key, commentColumns, commentVisible, page.where, page.orderBy, page.limit)
+
+	args = append(args, page.args...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
-	rows, err := c.DB.QueryContext(ctx, query, postID, filters.limit(), filters.offset())
+	rows, err := c.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
 	totalRecords := 0
-	var comments []*Comment
+	comments := []*Comment{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
-		var comment Comment
-		err := rows.Scan(
-			&totalRecords,
-			&comment.ID,
-			&comment.PostID,
-			&comment.UserID,
-			&comment.Content,
-			&comment.CreatedAt,
-			&comment.UpdatedAt,
+		var (
+			comment Comment
+			key     cursorKey
 		)
+		err := rows.Scan(append([]any{&totalRecords, &key.Value}, comment.scanDest()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		key.ID = comment.ID
 		comments = append(comments, &comment)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	comments, metadata := paginate(comments, keys, filters, totalRecords)
 
 	return comments, metadata, nil
 }
 
-func (c CommentModel) GetAllByUser(userID int64, filters Filters) ([]*Comment, Metadata, error) {
+// GetReplies returns every reply, direct or nested, to the given comments in
+// the order they were written.
+func (c CommentModel) GetReplies(ctx context.Context, parentIDs []int64) ([]*Comment, error) {
+	query := `
+        WITH RECURSIVE thread AS (
+            SELECT id FROM comments WHERE parent_comment_id = ANY($1)
+            UNION ALL
+            SELECT comments.id FROM comments
+            INNER JOIN thread ON comments.parent_comment_id = thread.id
+        )
+        SELECT ` + commentColumns + `
+        FROM comments
+        INNER JOIN thread ON thread.id = comments.id
+        WHERE ` + commentVisible + `
+        ORDER BY comments.created_at, comments.id`
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, pq.Array(parentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(comment.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// NestComments attaches each reply to its parent's Replies, so that the
+// returned roots form comment trees.
+func NestComments(roots, replies []*Comment) []*Comment {
+	byID := make(map[int64]*Comment, len(roots)+len(replies))
+
+	for _, comment := range roots {
+		byID[comment.ID] = comment
+	}
+	for _, comment := range replies {
+		byID[comment.ID] = comment
+	}
+
+	for _, reply := range replies {
+		if reply.ParentCommentID == nil {
+			continue
+		}
+
+		if parent, ok := byID[*reply.ParentCommentID]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+
+	return roots
+}
+
+// GetAllByUser lists a user's comments on published posts, plus those on
+// unpublished posts written by viewerID. Anonymous viewers should pass a
+// viewerID of 0.
+func (c CommentModel) GetAllByUser(ctx context.Context, userID, viewerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	args := []any{userID, viewerID}
+
+	page, err := filters.pageQuery("comments.", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, post_id, user_id, content, created_at, updated_at
+		SELECT %s, %s, %s
 		FROM comments
-		WHERE user_id = $1
-		ORDER BY %s %s
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		JOIN posts ON posts.id = comments.post_id
+		WHERE comments.user_id = $1 AND comments.deleted_at IS NULL
+		AND (posts.status = 'published' OR posts.user_id = $2)
+		AND posts.deleted_at IS NULL
+		AND %s
+		ORDER BY %s
+		%s`, page.count, page.key, commentColumns, page.where, page.orderBy, page.limit)
 
-	args := []any{userID, filters.limit(), filters.offset()}
+	args = append(args, page.args...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
 	rows, err := c.DB.QueryContext(ctx, query, args...)
@@ -128,22 +277,20 @@ func (c CommentModel) GetAllByUser(userID int64, filters Filters) ([]*Comment, M
 
 	totalRecords := 0
 	comments := []*Comment{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
-		var comment Comment
-		err := rows.Scan(
-			&totalRecords,
-			&comment.ID,
-			&comment.PostID,
-			&comment.UserID,
-			&comment.Content,
-			&comment.CreatedAt,
-			&comment.UpdatedAt,
+		var (
+			comment Comment
+			key     cursorKey
 		)
+		err := rows.Scan(append([]any{&totalRecords, &key.Value}, comment.scanDest()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		key.ID = comment.ID
 		comments = append(comments, &comment)
+		keys = append(keys, key)
 	}
 
 	err = rows.Err()
@@ -151,34 +298,53 @@ func (c CommentModel) GetAllByUser(userID int64, filters Filters) ([]*Comment, M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	comments, metadata := paginate(comments, keys, filters, totalRecords)
 	return comments, metadata, nil
 }
 
-func (c CommentModel) Insert(comment *Comment) error {
+// Insert adds a comment to a post, returning ErrRecordNotFound if the post
+// doesn't exist or is in the trash.
+func (c CommentModel) Insert(ctx context.Context, comment *Comment) error {
 	query := `
-	INSERT INTO comments(post_id, user_id, content)
-	VALUES ($1, $2, $3)
+	INSERT INTO comments(post_id, user_id, parent_comment_id, depth, content)
+	SELECT $1, $2, $3, $4, $5
+	WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)
 	RETURNING id, created_at, updated_at`
 
-	args := []any{comment.PostID, comment.UserID, comment.Content}
+	args := []any{comment.PostID, comment.UserID, comment.ParentCommentID, comment.Depth, comment.Content}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt)
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt)
+	if err != nil {
+		// The post can still be removed between the check and the insert,
+		// which the foreign key catches.
+		var pqErr *pq.Error
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (c CommentModel) Update(comment *Comment) error {
+func (c CommentModel) Update(ctx context.Context, comment *Comment) error {
 	query := `
 		UPDATE comments
 		SET content = $1, updated_at = NOW()
-		WHERE id = $2 AND updated_at = $3
+		WHERE id = $2 AND updated_at = $3 AND deleted_at IS NULL
 		RETURNING updated_at`
 
 	args := []any{comment.Content, comment.ID, comment.UpdatedAt}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
 	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.UpdatedAt)
@@ -195,19 +361,23 @@ func (c CommentModel) Update(comment *Comment) error {
 	return nil
 }
 
-func (c CommentModel) Delete(id, userID, postID int64) error {
+// Delete moves the comment to the trash if it belongs to userID. When
+// override is set the ownership check is skipped, for users allowed to
+// moderate any comment.
+func (c CommentModel) Delete(ctx context.Context, id, userID, postID int64, override bool) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-        DELETE FROM comments
-        WHERE id = $1 AND user_id = $2 AND post_id = $3`
+        UPDATE comments
+        SET deleted_at = NOW()
+        WHERE id = $1 AND (user_id = $2 OR $4) AND post_id = $3 AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
-	result, err := c.DB.ExecContext(ctx, query, id, userID, postID)
+	result, err := c.DB.ExecContext(ctx, query, id, userID, postID, override)
 	if err != nil {
 		return err
 	}
@@ -221,7 +391,7 @@ func (c CommentModel) Delete(id, userID, postID int64) error {
 		existsQuery := `
             SELECT user_id 
             FROM comments 
-            WHERE id = $1 AND post_id = $2`
+            WHERE id = $1 AND post_id = $2 AND deleted_at IS NULL`
 
 		var ownerID int64
 		err := c.DB.QueryRowContext(ctx, existsQuery, id, postID).Scan(&ownerID)
@@ -239,3 +409,142 @@ func (c CommentModel) Delete(id, userID, postID int64) error {
 
 	return nil
 }
+
+// Restore takes the comment out of the trash. The same ownership rules as
+// Delete apply, and ErrRecordNotFound is returned if the post doesn't exist
+// or is in the trash.
+func (c CommentModel) Restore(ctx context.Context, id, userID, postID int64, override bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        UPDATE comments
+        SET deleted_at = NULL
+        WHERE id = $1 AND (user_id = $2 OR $4) AND post_id = $3 AND deleted_at IS NOT NULL
+        AND EXISTS (SELECT 1 FROM posts WHERE id = $3 AND deleted_at IS NULL)`
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, id, userID, postID, override)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		existsQuery := `
+            SELECT comments.user_id
+            FROM comments
+            INNER JOIN posts ON posts.id = comments.post_id
+            WHERE comments.id = $1 AND comments.post_id = $2 AND comments.deleted_at IS NOT NULL
+            AND posts.deleted_at IS NULL`
+
+		var ownerID int64
+		err := c.DB.QueryRowContext(ctx, existsQuery, id, postID).Scan(&ownerID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		if ownerID != userID {
+			return ErrUnauthorized
+		}
+	}
+
+	return nil
+}
+
+func (c CommentModel) GetDeletedByUser(ctx context.Context, userID int64, filters Filters) ([]*Comment, Metadata, error) {
+	args := []any{userID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, post_id, user_id, parent_comment_id, depth, content, created_at, updated_at, deleted_at
+		FROM comments
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		AND %s
+		ORDER BY %s
+		%s`, page.count, page.key, page.where, page.orderBy, page.limit)
+
+	args = append(args, page.args...)
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var (
+			comment Comment
+			key     cursorKey
+		)
+		err := rows.Scan(
+			&totalRecords,
+			&key.Value,
+			&comment.ID,
+			&comment.PostID,
+			&comment.UserID,
+			&comment.ParentCommentID,
+			&comment.Depth,
+			&comment.Content,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comment.Deleted = true
+		key.ID = comment.ID
+		comments = append(comments, &comment)
+		keys = append(keys, key)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	comments, metadata := paginate(comments, keys, filters, totalRecords)
+	return comments, metadata, nil
+}
+
+// PurgeDeleted permanently removes comments that were moved to the trash
+// before the given time. Comments that still have live replies are kept as
+// placeholders until their replies are gone.
+func (c CommentModel) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM comments
+		WHERE deleted_at < $1
+		AND NOT ` + hasLiveReplies("comments")
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
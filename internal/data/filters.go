@@ -1,16 +1,32 @@
 package data
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/manuelam2003/blogly/internal/validator"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// After and Before are cursors taken from a previous page's metadata.
+	// When either is set Page is ignored and rows are fetched by keyset
+	// instead of by offset.
+	After     string
+	Before    string
+	SkipCount bool
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -20,6 +36,18 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	v.Check(f.After == "" || f.Before == "", "before", "must not be used together with after")
+
+	if f.After != "" {
+		_, err := decodeCursor(f.After, f.Sort)
+		v.Check(err == nil, "after", "must be a valid cursor for this sort")
+	}
+
+	if f.Before != "" {
+		_, err := decodeCursor(f.Before, f.Sort)
+		v.Check(err == nil, "before", "must be a valid cursor for this sort")
+	}
 }
 
 func (f Filters) sortColumn() string {
@@ -48,12 +76,207 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+func (f Filters) usesCursor() bool {
+	return f.After != "" || f.Before != ""
+}
+
+// cursorKey is the position of a row in a sorted listing: the value of the
+// sort column, as text, and the row's id to break ties.
+type cursorKey struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+// cursorSecret signs cursors so that clients can't forge positions. It is
+// random unless SetCursorSecret is called, which means cursors stop working
+// when the process restarts.
+var cursorSecret = func() []byte {
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	return secret
+}()
+
+func SetCursorSecret(secret string) {
+	cursorSecret = []byte(secret)
+}
+
+func signCursor(payload string) string {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeCursor(key cursorKey) string {
+	js, err := json.Marshal(key)
+	if err != nil {
+		panic(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+
+	return payload + "." + signCursor(payload)
+}
+
+func decodeCursor(cursor, sort string) (cursorKey, error) {
+	payload, signature, ok := strings.Cut(cursor, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signCursor(payload))) {
+		return cursorKey{}, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return cursorKey{}, ErrInvalidCursor
+	}
+
+	var key cursorKey
+
+	err = json.Unmarshal(js, &key)
+	if err != nil || key.Sort != sort {
+		return cursorKey{}, ErrInvalidCursor
+	}
+
+	return key, nil
+}
+
+// pageQuery holds the SQL fragments a list query needs to page through its
+// results, either by offset or by keyset.
+type pageQuery struct {
+	count   string
+	key     string
+	where   string
+	orderBy string
+	limit   string
+	args    []any
+}
+
+// pageQuery builds the fragments for a list query whose own arguments take
+// up the first argCount placeholders. qualifier is prepended to column names,
+// for queries that join other tables.
+//
+// One row more than the page size is fetched, so that paginate can tell
+// whether there is another page.
+func (f Filters) pageQuery(qualifier string, argCount int) (pageQuery, error) {
+	column := qualifier + f.sortColumn()
+	id := qualifier + "id"
+	direction := f.sortDirection()
+
+	q := pageQuery{
+		count: "count(*) OVER()",
+		key:   column + "::text",
+		where: "TRUE",
+	}
+
+	// The total is skipped when paging by cursor, since the window would
+	// only count the rows past the cursor.
+	if f.SkipCount || f.usesCursor() {
+		q.count = "0"
+	}
+
+	var (
+		cursor   string
+		operator = ">"
+	)
+
+	if direction == "DESC" {
+		operator = "<"
+	}
+
+	switch {
+	case f.After != "":
+		cursor = f.After
+	case f.Before != "":
+		// Walk backwards from the cursor; paginate puts the rows back in
+		// order afterwards.
+		cursor = f.Before
+		if direction == "DESC" {
+			direction, operator = "ASC", ">"
+		} else {
+			direction, operator = "DESC", "<"
+		}
+	}
+
+	offset := 0
+
+	if cursor != "" {
+		key, err := decodeCursor(cursor, f.Sort)
+		if err != nil {
+			return pageQuery{}, err
+		}
+
+		q.where = fmt.Sprintf("(%s, %s) %s ($%d, $%d)", column, id, operator, argCount+1, argCount+2)
+		q.args = append(q.args, key.Value, key.ID)
+	} else {
+		offset = f.offset()
+	}
+
+	q.orderBy = fmt.Sprintf("%s %s, %s %s", column, direction, id, direction)
+
+	q.limit = fmt.Sprintf("LIMIT $%d OFFSET $%d", argCount+len(q.args)+1, argCount+len(q.args)+2)
+	q.args = append(q.args, f.limit()+1, offset)
+
+	return q, nil
+}
+
+// paginate trims the extra row fetched by pageQuery, restores the order of
+// rows fetched backwards, and builds the metadata, including cursors for the
+// neighbouring pages. keys holds the position of each of the items.
+func paginate[T any](items []T, keys []cursorKey, f Filters, totalRecords int) ([]T, Metadata) {
+	more := len(items) > f.PageSize
+	if more {
+		items, keys = items[:f.PageSize], keys[:f.PageSize]
+	}
+
+	if f.Before != "" {
+		slices.Reverse(items)
+		slices.Reverse(keys)
+	}
+
+	var metadata Metadata
+
+	if f.SkipCount || f.usesCursor() {
+		metadata = Metadata{PageSize: f.PageSize}
+	} else {
+		metadata = calculateMetadata(totalRecords, f.Page, f.PageSize)
+	}
+
+	if len(items) == 0 {
+		return items, metadata
+	}
+
+	hasNext, hasPrev := more, f.After != "" || (!f.usesCursor() && f.Page > 1)
+	if f.Before != "" {
+		hasNext, hasPrev = true, more
+	}
+
+	if hasNext {
+		key := keys[len(keys)-1]
+		key.Sort = f.Sort
+		metadata.NextCursor = encodeCursor(key)
+	}
+
+	if hasPrev {
+		key := keys[0]
+		key.Sort = f.Sort
+		metadata.PrevCursor = encodeCursor(key)
+	}
+
+	return items, metadata
+}
+
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
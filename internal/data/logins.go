@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures counts the failed logins for an account or an IP address. The
+// count starts over once there has been no failure for a lockout period.
+type LoginFailures struct {
+	Count         int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Locked reports whether logins are locked out at the given time.
+func (f *LoginFailures) Locked(at time.Time) bool {
+	return f.LockedUntil != nil && f.LockedUntil.After(at)
+}
+
+type LoginFailureModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m LoginFailureModel) Get(ctx context.Context, key string) (*LoginFailures, error) {
+	query := `
+        SELECT failures, last_failure_at, locked_until
+        FROM login_failures
+        WHERE key = $1`
+
+	var failures LoginFailures
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&failures.Count, &failures.LastFailureAt, &failures.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &failures, nil
+}
+
+// Wait returns how long logins have to wait after these failures before
+// another attempt, or 0 if one may be made now. Once half of maxFailures are
+// used up, each further attempt has to wait twice as long after the last
+// failure as the one before it, and a lockout makes them wait until it ends.
+func (f *LoginFailures) Wait(maxFailures int, backoff, lockout time.Duration, now time.Time) time.Duration {
+	if f.Locked(now) {
+		return f.LockedUntil.Sub(now)
+	}
+
+	// The count starts over once there has been no failure for a lockout
+	// period.
+	if now.Sub(f.LastFailureAt) >= lockout {
+		return 0
+	}
+
+	free := maxFailures / 2
+	if f.Count < free {
+		return 0
+	}
+
+	delay := lockout
+	if shift := f.Count - free; shift < 32 {
+		delay = min(backoff<<shift, lockout)
+	}
+
+	return max(f.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+// Reserve counts a login attempt for key as a failure before the password is
+// checked, unless the failures so far make the attempt wait, in which case
+// nothing is counted and the current failures are returned with reserved
+// false. The check and the count are one conditional statement, so that
+// concurrent attempts can't all get past the backoff. When the count reaches
+// maxFailures, logins are locked out for the lockout period. An attempt that
+// turns out to succeed gives its failure back with Release.
+func (m LoginFailureModel) Reserve(ctx context.Context, key string, maxFailures int, backoff, lockout time.Duration) (*LoginFailures, bool, error) {
+	// The WHERE clause is LoginFailures.Wait, evaluated on the locked row.
+	query := `
+        INSERT INTO login_failures AS f (key, failures, last_failure_at, locked_until)
+        VALUES ($1, 1, NOW(), CASE WHEN $2 <= 1 THEN NOW() + make_interval(secs => $3) END)
+        ON CONFLICT (key) DO UPDATE
+        SET (failures, last_failure_at, locked_until) = (
+            SELECT attempts, NOW(), CASE WHEN attempts >= $2 THEN NOW() + make_interval(secs => $3) END
+            FROM (
+                SELECT CASE WHEN f.last_failure_at < NOW() - make_interval(secs => $3) THEN 1 ELSE f.failures + 1 END AS attempts
+            ) AS counted
+        )
+        WHERE (f.locked_until IS NULL OR f.locked_until <= NOW())
+        AND (
+            f.last_failure_at <= NOW() - make_interval(secs => $3)
+            OR f.failures < $2 / 2
+            OR f.last_failure_at + CASE
+                WHEN f.failures - $2 / 2 < 32 THEN LEAST(make_interval(secs => $4 * 2 ^ (f.failures - $2 / 2)), make_interval(secs => $3))
+                ELSE make_interval(secs => $3)
+            END <= NOW()
+        )
+        RETURNING failures, last_failure_at, locked_until`
+
+	var failures LoginFailures
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, maxFailures, lockout.Seconds(), backoff.Seconds()).Scan(&failures.Count, &failures.LastFailureAt, &failures.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			current, err := m.Get(ctx, key)
+			return current, false, err
+		default:
+			return nil, false, err
+		}
+	}
+
+	return &failures, true, nil
+}
+
+// Release gives back a failure Reserve counted for key, lifting the lockout
+// if the count drops below maxFailures again.
+func (m LoginFailureModel) Release(ctx context.Context, key string, maxFailures int) error {
+	query := `
+        UPDATE login_failures
+        SET failures = failures - 1, locked_until = CASE WHEN failures - 1 >= $2 THEN locked_until END
+        WHERE key = $1 AND failures > 0`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, maxFailures)
+	return err
+}
+
+// Delete forgets the failures for key, lifting any lockout.
+func (m LoginFailureModel) Delete(ctx context.Context, key string) error {
+	query := `
+        DELETE FROM login_failures
+        WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteStale removes the failures last recorded before the given time whose
+// lockout, if any, has ended by then.
+func (m LoginFailureModel) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+        DELETE FROM login_failures
+        WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginFailuresWait(t *testing.T) {
+	now := time.Now()
+	lockedUntil := now.Add(10 * time.Minute)
+
+	tests := []struct {
+		name     string
+		failures LoginFailures
+		want     time.Duration
+	}{
+		{name: "few failures", failures: LoginFailures{Count: 4, LastFailureAt: now}, want: 0},
+		{name: "half the failures", failures: LoginFailures{Count: 5, LastFailureAt: now}, want: time.Second},
+		{name: "backoff doubles", failures: LoginFailures{Count: 8, LastFailureAt: now}, want: 8 * time.Second},
+		{name: "backoff has passed", failures: LoginFailures{Count: 8, LastFailureAt: now.Add(-10 * time.Second)}, want: 0},
+		{name: "backoff keeps doubling", failures: LoginFailures{Count: 9, LastFailureAt: now}, want: 16 * time.Second},
+		{name: "locked", failures: LoginFailures{Count: 10, LastFailureAt: now, LockedUntil: &lockedUntil}, want: 10 * time.Minute},
+		{name: "old failures", failures: LoginFailures{Count: 9, LastFailureAt: now.Add(-time.Hour)}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.failures.Wait(10, time.Second, 15*time.Minute, now); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryLoginFailureStore(t *testing.T) {
+	testLoginFailureStore(t, NewMemoryModels().LoginFailures)
+}
+
+// TestPostgresLoginFailureStore needs a database with the migrations applied,
+// given by BLOGLY_TEST_DB_DSN.
+func TestPostgresLoginFailureStore(t *testing.T) {
+	dsn := os.Getenv("BLOGLY_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("BLOGLY_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("DELETE FROM login_failures WHERE key LIKE 'test:%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLoginFailureStore(t, LoginFailureModel{DB: db, Timeout: DefaultQueryTimeout})
+}
+
+// testLoginFailureStore has a burst of concurrent attempts reserved against
+// the same key, and checks that only the free ones get past the backoff.
+func testLoginFailureStore(t *testing.T, store LoginFailureStore) {
+	t.Helper()
+
+	const (
+		attempts    = 20
+		maxFailures = 10
+	)
+
+	ctx := context.Background()
+	key := "test:" + t.Name()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, ok, err := store.Reserve(ctx, key, maxFailures, time.Hour, 2*time.Hour)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// The first half of the failures are free, and the attempt after them
+	// has to wait out the backoff.
+	if want := maxFailures / 2; reserved != want {
+		t.Errorf("got %d attempts reserved; want %d", reserved, want)
+	}
+
+	failures, ok, err := store.Reserve(ctx, key, maxFailures, time.Hour, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok || failures.Count != reserved {
+		t.Errorf("got %+v, reserved %t; want %d failures and the attempt turned away", failures, ok, reserved)
+	}
+
+	err = store.Release(ctx, key, maxFailures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err = store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failures.Count != reserved-1 {
+		t.Errorf("got %d failures after a release; want %d", failures.Count, reserved-1)
+	}
+}
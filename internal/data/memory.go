@@ -0,0 +1,1719 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryDB holds the tables behind the in-memory stores. A single mutex
+// guards every table, which keeps the cascades between them simple.
+type memoryDB struct {
+	mu sync.Mutex
+
+	users     map[int64]*User
+	posts     map[int64]*Post
+	revisions map[int64]*PostRevision
+	comments  map[int64]*Comment
+	tags      map[int64]*Tag
+	postTags  map[[2]int64]bool
+	tokens    map[string]*memoryToken
+	logins    map[string]*LoginFailures
+	audit     map[int64]*AuditEntry
+
+	lastID map[string]int64
+}
+
+type memoryToken struct {
+	Token
+	rotatedAt *time.Time
+}
+
+// NewMemoryModels returns models backed by in-memory stores that behave like
+// the Postgres ones, for running the application without a database.
+func NewMemoryModels() Models {
+	db := &memoryDB{
+		users:     make(map[int64]*User),
+		posts:     make(map[int64]*Post),
+		revisions: make(map[int64]*PostRevision),
+		comments:  make(map[int64]*Comment),
+		tags:      make(map[int64]*Tag),
+		postTags:  make(map[[2]int64]bool),
+		tokens:    make(map[string]*memoryToken),
+		logins:    make(map[string]*LoginFailures),
+		audit:     make(map[int64]*AuditEntry),
+		lastID:    make(map[string]int64),
+	}
+
+	return Models{
+		Posts:         memoryPostStore{db},
+		Revisions:     memoryPostRevisionStore{db},
+		Users:         memoryUserStore{db},
+		Comments:      memoryCommentStore{db},
+		Tags:          memoryTagStore{db},
+		PostTags:      memoryPostTagStore{db},
+		Tokens:        memoryTokenStore{db},
+		LoginFailures: memoryLoginFailureStore{db},
+		Audit:         memoryAuditStore{db},
+		RateLimits:    NewMemoryRateLimitStore(),
+	}
+}
+
+func (db *memoryDB) nextID(table string) int64 {
+	db.lastID[table]++
+	return db.lastID[table]
+}
+
+// memoryNow stands in for NOW(). It keeps the microsecond precision Postgres
+// stores timestamps with.
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// memoryMatches approximates to_tsvector('simple', text) @@
+// plainto_tsquery('simple', query): every word of the query has to appear as
+// a word of the text, ignoring case.
+func memoryMatches(text, query string) bool {
+	words := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+
+	textWords := words(text)
+
+	for _, word := range words(query) {
+		if !slices.Contains(textWords, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sort keys are compared as strings, so numbers and times are formatted so
+// that their string order matches their natural order.
+func intSortKey(n int64) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+func timeSortKey(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format("2006-01-02T15:04:05.000000")
+}
+
+// memoryPage sorts, filters and pages items the way pageQuery and paginate
+// do for the Postgres models.
+func memoryPage[T any](items []T, f Filters, id func(T) int64, sortKey func(T, string) string) ([]T, Metadata, error) {
+	column := f.sortColumn()
+	descending := f.sortDirection() == "DESC"
+
+	compare := func(a, b T) int {
+		c := strings.Compare(sortKey(a, column), sortKey(b, column))
+		if c == 0 {
+			c = cmp.Compare(id(a), id(b))
+		}
+		if descending {
+			c = -c
+		}
+		return c
+	}
+
+	slices.SortFunc(items, compare)
+
+	totalRecords := len(items)
+
+	if f.usesCursor() {
+		cursor := f.After
+		if cursor == "" {
+			cursor = f.Before
+		}
+
+		key, err := decodeCursor(cursor, f.Sort)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// position is positive for items listed after the cursor.
+		position := func(item T) int {
+			c := strings.Compare(sortKey(item, column), key.Value)
+			if c == 0 {
+				c = cmp.Compare(id(item), key.ID)
+			}
+			if descending {
+				c = -c
+			}
+			return c
+		}
+
+		if f.After != "" {
+			items = slices.DeleteFunc(items, func(item T) bool { return position(item) <= 0 })
+		} else {
+			items = slices.DeleteFunc(items, func(item T) bool { return position(item) >= 0 })
+			slices.Reverse(items)
+		}
+	} else {
+		items = items[min(f.offset(), len(items)):]
+	}
+
+	items = items[:min(f.limit()+1, len(items))]
+
+	// count(*) OVER() has nothing to count when the page is empty.
+	if len(items) == 0 {
+		totalRecords = 0
+	}
+
+	keys := make([]cursorKey, len(items))
+	for i, item := range items {
+		keys[i] = cursorKey{Value: sortKey(item, column), ID: id(item)}
+	}
+
+	items, metadata := paginate(items, keys, f, totalRecords)
+
+	return items, metadata, nil
+}
+
+// Hard deletes, following the ON DELETE CASCADE and SET NULL foreign keys.
+// The caller must hold db.mu.
+
+func (db *memoryDB) deleteUser(id int64) {
+	for _, post := range db.posts {
+		if post.UserID == id {
+			db.deletePost(post.ID)
+		}
+	}
+
+	for _, comment := range db.comments {
+		if comment.UserID == id {
+			db.deleteComment(comment.ID)
+		}
+	}
+
+	for hash, token := range db.tokens {
+		if token.UserID == id {
+			delete(db.tokens, hash)
+		}
+	}
+
+	// The audit log outlives the users it mentions (ON DELETE SET NULL).
+	for _, entry := range db.audit {
+		if entry.UserID != nil && *entry.UserID == id {
+			entry.UserID = nil
+		}
+		if entry.ActorID != nil && *entry.ActorID == id {
+			entry.ActorID = nil
+		}
+	}
+
+	delete(db.users, id)
+}
+
+func (db *memoryDB) deletePost(id int64) {
+	for _, comment := range db.comments {
+		if comment.PostID == id {
+			db.deleteComment(comment.ID)
+		}
+	}
+
+	for _, revision := range db.revisions {
+		if revision.PostID == id {
+			delete(db.revisions, revision.ID)
+		}
+	}
+
+	for key := range db.postTags {
+		if key[0] == id {
+			delete(db.postTags, key)
+		}
+	}
+
+	delete(db.posts, id)
+}
+
+func (db *memoryDB) deleteComment(id int64) {
+	delete(db.comments, id)
+
+	// Replies outlive their parent (ON DELETE SET NULL).
+	for _, reply := range db.comments {
+		if reply.ParentCommentID != nil && *reply.ParentCommentID == id {
+			reply.ParentCommentID = nil
+		}
+	}
+}
+
+type memoryPostStore struct {
+	db *memoryDB
+}
+
+func postSortKey(post *Post, column string) string {
+	switch column {
+	case "id":
+		return intSortKey(post.ID)
+	case "user_id":
+		return intSortKey(post.UserID)
+	case "title":
+		return post.Title
+	case "content":
+		return post.Content
+	case "created_at":
+		return timeSortKey(&post.CreatedAt)
+	case "updated_at":
+		return timeSortKey(&post.UpdatedAt)
+	case "deleted_at":
+		return timeSortKey(post.DeletedAt)
+	}
+
+	panic("unknown post sort column: " + column)
+}
+
+func postID(post *Post) int64 {
+	return post.ID
+}
+
+// insertRevision mirrors the Postgres insertRevision. The caller must hold
+// db.mu.
+func (db *memoryDB) insertRevision(post *Post) {
+	var latest *PostRevision
+
+	for _, revision := range db.revisions {
+		if revision.PostID == post.ID && (latest == nil || revision.ID > latest.ID) {
+			latest = revision
+		}
+	}
+
+	if latest != nil && latest.Title == post.Title && latest.Content == post.Content {
+		return
+	}
+
+	id := db.nextID("post_revisions")
+	db.revisions[id] = &PostRevision{
+		ID:        id,
+		PostID:    post.ID,
+		Title:     post.Title,
+		Content:   post.Content,
+		CreatedAt: post.UpdatedAt,
+	}
+}
+
+func (s memoryPostStore) Insert(ctx context.Context, post *Post) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	post.ID = s.db.nextID("posts")
+	post.CreatedAt = memoryNow()
+	post.UpdatedAt = post.CreatedAt
+
+	stored := *post
+	stored.DeletedAt = nil
+	s.db.posts[post.ID] = &stored
+
+	s.db.insertRevision(&stored)
+
+	return nil
+}
+
+func (s memoryPostStore) Get(ctx context.Context, id int64) (*Post, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	post, ok := s.db.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *post
+	return &found, nil
+}
+
+func (s memoryPostStore) Update(ctx context.Context, post *Post) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.posts[post.ID]
+	if !ok || stored.DeletedAt != nil || !stored.UpdatedAt.Equal(post.UpdatedAt) {
+		return ErrEditConflict
+	}
+
+	stored.Title = post.Title
+	stored.Content = post.Content
+	stored.Status = post.Status
+	stored.PublishAt = post.PublishAt
+	stored.UpdatedAt = memoryNow()
+
+	post.UpdatedAt = stored.UpdatedAt
+
+	s.db.insertRevision(stored)
+
+	return nil
+}
+
+func (s memoryPostStore) Delete(ctx context.Context, id int64, userID int64, override bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	post, ok := s.db.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if post.UserID != userID && !override {
+		return ErrUnauthorized
+	}
+
+	now := memoryNow()
+	post.DeletedAt = &now
+
+	for _, comment := range s.db.comments {
+		if comment.PostID == id && comment.DeletedAt == nil {
+			comment.DeletedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (s memoryPostStore) Restore(ctx context.Context, id int64, userID int64, override bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	post, ok := s.db.posts[id]
+	if !ok || post.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	if post.UserID != userID && !override {
+		return ErrUnauthorized
+	}
+
+	for _, comment := range s.db.comments {
+		if comment.PostID == id && comment.DeletedAt != nil && comment.DeletedAt.Equal(*post.DeletedAt) {
+			comment.DeletedAt = nil
+		}
+	}
+
+	post.DeletedAt = nil
+
+	return nil
+}
+
+func (s memoryPostStore) GetDeletedForUser(ctx context.Context, userID int64, filters Filters) ([]*Post, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	posts := []*Post{}
+
+	for _, post := range s.db.posts {
+		if post.UserID == userID && post.DeletedAt != nil {
+			found := *post
+			posts = append(posts, &found)
+		}
+	}
+
+	return memoryPage(posts, filters, postID, postSortKey)
+}
+
+func (s memoryPostStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var purged int64
+
+	for _, post := range s.db.posts {
+		if post.DeletedAt != nil && post.DeletedAt.Before(before) {
+			s.db.deletePost(post.ID)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (s memoryPostStore) GetAll(ctx context.Context, userID int64, title, content string, viewerID int64, filters Filters) ([]*Post, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	posts := []*Post{}
+
+	for _, post := range s.db.posts {
+		if post.DeletedAt != nil ||
+			!memoryMatches(post.Title, title) ||
+			!memoryMatches(post.Content, content) ||
+			(userID != 0 && post.UserID != userID) ||
+			(!post.IsPublished() && post.UserID != viewerID) {
+			continue
+		}
+
+		found := *post
+		posts = append(posts, &found)
+	}
+
+	return memoryPage(posts, filters, postID, postSortKey)
+}
+
+func (s memoryPostStore) GetAllForUser(ctx context.Context, userID int64, viewerID int64, filters Filters) ([]*Post, Metadata, error) {
+	return s.GetAll(ctx, userID, "", "", viewerID, filters)
+}
+
+func (s memoryPostStore) PublishScheduled(ctx context.Context) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var published int64
+	now := memoryNow()
+
+	for _, post := range s.db.posts {
+		if post.Status == PostStatusScheduled && post.PublishAt != nil && !post.PublishAt.After(now) && post.DeletedAt == nil {
+			post.Status = PostStatusPublished
+			post.UpdatedAt = now
+			published++
+		}
+	}
+
+	return published, nil
+}
+
+type memoryPostRevisionStore struct {
+	db *memoryDB
+}
+
+func (s memoryPostRevisionStore) Get(ctx context.Context, postID, revisionID int64) (*PostRevision, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	revision, ok := s.db.revisions[revisionID]
+	if !ok || revision.PostID != postID {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *revision
+	return &found, nil
+}
+
+func (s memoryPostRevisionStore) GetPrevious(ctx context.Context, postID, revisionID int64) (*PostRevision, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var previous *PostRevision
+
+	for _, revision := range s.db.revisions {
+		if revision.PostID == postID && revision.ID < revisionID && (previous == nil || revision.ID > previous.ID) {
+			previous = revision
+		}
+	}
+
+	if previous == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *previous
+	return &found, nil
+}
+
+func (s memoryPostRevisionStore) GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*PostRevision, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	revisions := []*PostRevision{}
+
+	for _, revision := range s.db.revisions {
+		if revision.PostID == postID {
+			found := *revision
+			revisions = append(revisions, &found)
+		}
+	}
+
+	id := func(revision *PostRevision) int64 { return revision.ID }
+
+	sortKey := func(revision *PostRevision, column string) string {
+		switch column {
+		case "id":
+			return intSortKey(revision.ID)
+		case "created_at":
+			return timeSortKey(&revision.CreatedAt)
+		}
+
+		panic("unknown revision sort column: " + column)
+	}
+
+	return memoryPage(revisions, filters, id, sortKey)
+}
+
+type memoryUserStore struct {
+	db *memoryDB
+}
+
+func userSortKey(user *User, column string) string {
+	switch column {
+	case "id":
+		return intSortKey(user.ID)
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "created_at":
+		return timeSortKey(&user.CreatedAt)
+	case "updated_at":
+		return timeSortKey(&user.UpdatedAt)
+	}
+
+	panic("unknown user sort column: " + column)
+}
+
+// checkUnique mirrors the unique constraints on users, which also cover
+// deleted users. The caller must hold db.mu.
+func (s memoryUserStore) checkUnique(user *User) error {
+	for _, other := range s.db.users {
+		if other.ID == user.ID {
+			continue
+		}
+
+		switch {
+		case other.Email == user.Email:
+			return ErrDuplicateEmail
+		case other.Username == user.Username:
+			return ErrDuplicateUsername
+		}
+	}
+
+	return nil
+}
+
+func (s memoryUserStore) Insert(ctx context.Context, user *User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if user.Role == "" {
+		user.Role = RoleAuthor
+	}
+
+	user.ID = 0
+
+	err := s.checkUnique(user)
+	if err != nil {
+		return err
+	}
+
+	user.ID = s.db.nextID("users")
+	user.CreatedAt = memoryNow()
+	user.UpdatedAt = user.CreatedAt
+
+	stored := *user
+	stored.Password.plaintext = nil
+	stored.DeletedAt = nil
+	s.db.users[user.ID] = &stored
+
+	return nil
+}
+
+func (s memoryUserStore) get(match func(*User) bool) (*User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, user := range s.db.users {
+		if user.DeletedAt == nil && match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s memoryUserStore) GetByID(ctx context.Context, id int64) (*User, error) {
+	return s.get(func(user *User) bool { return user.ID == id })
+}
+
+func (s memoryUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return s.get(func(user *User) bool { return user.Email == email })
+}
+
+func (s memoryUserStore) Update(ctx context.Context, user *User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.users[user.ID]
+	if !ok || stored.DeletedAt != nil || !stored.UpdatedAt.Equal(user.UpdatedAt) {
+		return ErrEditConflict
+	}
+
+	err := s.checkUnique(user)
+	if err != nil {
+		return err
+	}
+
+	if stored.Role == RoleAdmin && stored.Activated && user.Role != RoleAdmin && !s.otherAdmins(user.ID) {
+		return ErrLastAdmin
+	}
+
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.Password.hash = user.Password.hash
+	stored.Activated = user.Activated
+	stored.Role = user.Role
+	stored.UpdatedAt = memoryNow()
+
+	user.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+// otherAdmins reports whether an active admin other than id exists. The
+// caller must hold db.mu.
+func (s memoryUserStore) otherAdmins(id int64) bool {
+	for _, user := range s.db.users {
+		if user.ID != id && user.Role == RoleAdmin && user.Activated && user.DeletedAt == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s memoryUserStore) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.tokens[string(hashToken(tokenPlaintext))]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := s.db.users[token.UserID]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *user
+	return &found, nil
+}
+
+func (s memoryUserStore) Delete(ctx context.Context, id int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	now := memoryNow()
+	user.DeletedAt = &now
+
+	for _, post := range s.db.posts {
+		if post.UserID == id && post.DeletedAt == nil {
+			post.DeletedAt = &now
+		}
+	}
+
+	for _, comment := range s.db.comments {
+		if comment.DeletedAt != nil {
+			continue
+		}
+
+		if post, ok := s.db.posts[comment.PostID]; comment.UserID == id || (ok && post.UserID == id) {
+			comment.DeletedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (s memoryUserStore) Restore(ctx context.Context, id int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[id]
+	if !ok || user.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	deletedAt := *user.DeletedAt
+
+	for _, comment := range s.db.comments {
+		if comment.DeletedAt == nil || !comment.DeletedAt.Equal(deletedAt) {
+			continue
+		}
+
+		if post, ok := s.db.posts[comment.PostID]; comment.UserID == id || (ok && post.UserID == id) {
+			comment.DeletedAt = nil
+		}
+	}
+
+	for _, post := range s.db.posts {
+		if post.UserID == id && post.DeletedAt != nil && post.DeletedAt.Equal(deletedAt) {
+			post.DeletedAt = nil
+		}
+	}
+
+	user.DeletedAt = nil
+
+	return nil
+}
+
+func (s memoryUserStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var purged int64
+
+	comments := memoryCommentStore{s.db}
+
+	for _, user := range s.db.users {
+		if user.DeletedAt == nil || !user.DeletedAt.Before(before) {
+			continue
+		}
+
+		// Comments with live replies become anonymous placeholders, as in
+		// UserModel.PurgeDeleted.
+		for _, comment := range s.db.comments {
+			if comment.UserID == user.ID && comments.hasLiveReplies(comment.ID) {
+				comment.UserID = 0
+				comment.Content = DeletedCommentContent
+
+				if comment.DeletedAt == nil {
+					now := memoryNow()
+					comment.DeletedAt = &now
+				}
+			}
+		}
+
+		s.db.deleteUser(user.ID)
+		purged++
+	}
+
+	return purged, nil
+}
+
+func (s memoryUserStore) GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	users := []*User{}
+
+	for _, user := range s.db.users {
+		if user.DeletedAt == nil {
+			found := *user
+			users = append(users, &found)
+		}
+	}
+
+	id := func(user *User) int64 { return user.ID }
+
+	return memoryPage(users, filters, id, userSortKey)
+}
+
+type memoryCommentStore struct {
+	db *memoryDB
+}
+
+func commentSortKey(comment *Comment, column string) string {
+	switch column {
+	case "id":
+		return intSortKey(comment.ID)
+	case "user_id":
+		return intSortKey(comment.UserID)
+	case "created_at":
+		return timeSortKey(&comment.CreatedAt)
+	case "updated_at":
+		return timeSortKey(&comment.UpdatedAt)
+	case "deleted_at":
+		return timeSortKey(comment.DeletedAt)
+	}
+
+	panic("unknown comment sort column: " + column)
+}
+
+func commentID(comment *Comment) int64 {
+	return comment.ID
+}
+
+// hasLiveReplies mirrors the SQL function of the same name: it reports
+// whether a reply to id, direct or nested, isn't deleted. The caller must hold
+// db.mu.
+func (s memoryCommentStore) hasLiveReplies(id int64) bool {
+	for _, reply := range s.db.comments {
+		if reply.ParentCommentID != nil && *reply.ParentCommentID == id {
+			if reply.DeletedAt == nil || s.hasLiveReplies(reply.ID) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// visible mirrors commentVisible. The caller must hold db.mu.
+func (s memoryCommentStore) visible(comment *Comment) bool {
+	return comment.DeletedAt == nil || s.hasLiveReplies(comment.ID)
+}
+
+// view returns a copy of the comment as commentColumns selects it. The caller
+// must hold db.mu.
+func (s memoryCommentStore) view(comment *Comment) *Comment {
+	found := *comment
+	found.Replies = nil
+	found.DeletedAt = nil
+	found.ReplyCount = 0
+
+	if comment.DeletedAt != nil {
+		found.Content = DeletedCommentContent
+		found.Deleted = true
+	}
+
+	for _, reply := range s.db.comments {
+		if reply.ParentCommentID != nil && *reply.ParentCommentID == comment.ID && s.visible(reply) {
+			found.ReplyCount++
+		}
+	}
+
+	return &found
+}
+
+func (s memoryCommentStore) Get(ctx context.Context, postID, commentID int64) (*Comment, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comment, ok := s.db.comments[commentID]
+	if !ok || comment.PostID != postID || !s.visible(comment) {
+		return nil, ErrRecordNotFound
+	}
+
+	return s.view(comment), nil
+}
+
+func (s memoryCommentStore) GetAllForPost(ctx context.Context, postID int64, rootsOnly bool, filters Filters) ([]*Comment, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comments := []*Comment{}
+
+	for _, comment := range s.db.comments {
+		if comment.PostID != postID || (rootsOnly && comment.ParentCommentID != nil) || !s.visible(comment) {
+			continue
+		}
+
+		comments = append(comments, s.view(comment))
+	}
+
+	return memoryPage(comments, filters, commentID, commentSortKey)
+}
+
+func (s memoryCommentStore) GetReplies(ctx context.Context, parentIDs []int64) ([]*Comment, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comments := []*Comment{}
+	parents := slices.Clone(parentIDs)
+
+	for len(parents) > 0 {
+		var next []int64
+
+		for _, reply := range s.db.comments {
+			if reply.ParentCommentID == nil || !slices.Contains(parents, *reply.ParentCommentID) {
+				continue
+			}
+
+			next = append(next, reply.ID)
+
+			if s.visible(reply) {
+				comments = append(comments, s.view(reply))
+			}
+		}
+
+		parents = next
+	}
+
+	slices.SortFunc(comments, func(a, b *Comment) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return comments, nil
+}
+
+func (s memoryCommentStore) GetAllByUser(ctx context.Context, userID, viewerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comments := []*Comment{}
+
+	for _, comment := range s.db.comments {
+		if comment.UserID != userID || comment.DeletedAt != nil {
+			continue
+		}
+
+		post, ok := s.db.posts[comment.PostID]
+		if !ok || post.DeletedAt != nil || (!post.IsPublished() && post.UserID != viewerID) {
+			continue
+		}
+
+		comments = append(comments, s.view(comment))
+	}
+
+	return memoryPage(comments, filters, commentID, commentSortKey)
+}
+
+func (s memoryCommentStore) Insert(ctx context.Context, comment *Comment) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if post, ok := s.db.posts[comment.PostID]; !ok || post.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	comment.ID = s.db.nextID("comments")
+	comment.CreatedAt = memoryNow()
+	comment.UpdatedAt = comment.CreatedAt
+
+	stored := *comment
+	stored.Replies = nil
+	stored.Deleted = false
+	stored.ReplyCount = 0
+	stored.DeletedAt = nil
+	s.db.comments[comment.ID] = &stored
+
+	return nil
+}
+
+func (s memoryCommentStore) Update(ctx context.Context, comment *Comment) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.comments[comment.ID]
+	if !ok || stored.DeletedAt != nil || !stored.UpdatedAt.Equal(comment.UpdatedAt) {
+		return ErrEditConflict
+	}
+
+	stored.Content = comment.Content
+	stored.UpdatedAt = memoryNow()
+
+	comment.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+func (s memoryCommentStore) Delete(ctx context.Context, id, userID, postID int64, override bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comment, ok := s.db.comments[id]
+	if !ok || comment.PostID != postID || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if comment.UserID != userID && !override {
+		return ErrUnauthorized
+	}
+
+	now := memoryNow()
+	comment.DeletedAt = &now
+
+	return nil
+}
+
+func (s memoryCommentStore) Restore(ctx context.Context, id, userID, postID int64, override bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comment, ok := s.db.comments[id]
+	if !ok || comment.PostID != postID || comment.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	if post, ok := s.db.posts[postID]; !ok || post.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if comment.UserID != userID && !override {
+		return ErrUnauthorized
+	}
+
+	comment.DeletedAt = nil
+
+	return nil
+}
+
+func (s memoryCommentStore) GetDeletedByUser(ctx context.Context, userID int64, filters Filters) ([]*Comment, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	comments := []*Comment{}
+
+	for _, comment := range s.db.comments {
+		if comment.UserID == userID && comment.DeletedAt != nil {
+			found := *comment
+			found.Deleted = true
+			comments = append(comments, &found)
+		}
+	}
+
+	return memoryPage(comments, filters, commentID, commentSortKey)
+}
+
+func (s memoryCommentStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var purge []int64
+
+	for _, comment := range s.db.comments {
+		if comment.DeletedAt != nil && comment.DeletedAt.Before(before) && !s.hasLiveReplies(comment.ID) {
+			purge = append(purge, comment.ID)
+		}
+	}
+
+	for _, id := range purge {
+		s.db.deleteComment(id)
+	}
+
+	return int64(len(purge)), nil
+}
+
+type memoryTagStore struct {
+	db *memoryDB
+}
+
+func tagSortKey(tag *Tag, column string) string {
+	switch column {
+	case "id":
+		return intSortKey(tag.ID)
+	case "name":
+		return tag.Name
+	case "created_at":
+		return timeSortKey(&tag.CreatedAt)
+	case "updated_at":
+		return timeSortKey(&tag.UpdatedAt)
+	}
+
+	panic("unknown tag sort column: " + column)
+}
+
+func tagID(tag *Tag) int64 {
+	return tag.ID
+}
+
+func (s memoryTagStore) Insert(ctx context.Context, tag *Tag) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, other := range s.db.tags {
+		if other.Name == tag.Name {
+			return ErrDuplicateEntry
+		}
+	}
+
+	tag.ID = s.db.nextID("tags")
+	tag.CreatedAt = memoryNow()
+	tag.UpdatedAt = tag.CreatedAt
+
+	stored := *tag
+	s.db.tags[tag.ID] = &stored
+
+	return nil
+}
+
+func (s memoryTagStore) Get(ctx context.Context, id int64) (*Tag, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	tag, ok := s.db.tags[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *tag
+	return &found, nil
+}
+
+func (s memoryTagStore) Update(ctx context.Context, tag *Tag) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, other := range s.db.tags {
+		if other.ID != tag.ID && other.Name == tag.Name {
+			return ErrDuplicateEntry
+		}
+	}
+
+	stored, ok := s.db.tags[tag.ID]
+	if !ok || !stored.UpdatedAt.Equal(tag.UpdatedAt) {
+		return ErrEditConflict
+	}
+
+	stored.Name = tag.Name
+	stored.UpdatedAt = memoryNow()
+
+	tag.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+func (s memoryTagStore) Delete(ctx context.Context, id int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.tags[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	for key := range s.db.postTags {
+		if key[1] == id {
+			delete(s.db.postTags, key)
+		}
+	}
+
+	delete(s.db.tags, id)
+
+	return nil
+}
+
+func (s memoryTagStore) Merge(ctx context.Context, sourceID, targetID int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_, sourceOK := s.db.tags[sourceID]
+	_, targetOK := s.db.tags[targetID]
+
+	if !sourceOK || !targetOK || sourceID == targetID {
+		return ErrRecordNotFound
+	}
+
+	for key := range s.db.postTags {
+		if key[1] == sourceID {
+			s.db.postTags[[2]int64{key[0], targetID}] = true
+			delete(s.db.postTags, key)
+		}
+	}
+
+	delete(s.db.tags, sourceID)
+
+	return nil
+}
+
+func (s memoryTagStore) GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*Tag, Metadata, error) {
+	return s.GetAll(ctx, postID, "", filters)
+}
+
+func (s memoryTagStore) GetAll(ctx context.Context, postID int64, name string, filters Filters) ([]*Tag, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	tags := []*Tag{}
+
+	for _, tag := range s.db.tags {
+		if postID > 0 && !s.db.postTags[[2]int64{postID, tag.ID}] {
+			continue
+		}
+
+		if postID <= 0 && !memoryMatches(tag.Name, name) {
+			continue
+		}
+
+		found := *tag
+		tags = append(tags, &found)
+	}
+
+	return memoryPage(tags, filters, tagID, tagSortKey)
+}
+
+type memoryPostTagStore struct {
+	db *memoryDB
+}
+
+func (s memoryPostTagStore) Insert(ctx context.Context, postID, tagID int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	key := [2]int64{postID, tagID}
+
+	if s.db.postTags[key] {
+		return ErrDuplicateEntry
+	}
+
+	_, postExists := s.db.posts[postID]
+	_, tagExists := s.db.tags[tagID]
+
+	if !postExists || !tagExists {
+		return errors.New("post_tags: foreign key violation")
+	}
+
+	s.db.postTags[key] = true
+
+	return nil
+}
+
+func (s memoryPostTagStore) Delete(ctx context.Context, postID, tagID int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	key := [2]int64{postID, tagID}
+
+	if !s.db.postTags[key] {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.postTags, key)
+
+	return nil
+}
+
+type memoryTokenStore struct {
+	db *memoryDB
+}
+
+func (s memoryTokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Insert(ctx, token)
+	return token, err
+}
+
+// insert stores a copy of the token. The caller must hold db.mu.
+func (s memoryTokenStore) insert(token *Token) error {
+	if _, ok := s.db.users[token.UserID]; !ok {
+		return errors.New("tokens: foreign key violation")
+	}
+
+	stored := memoryToken{Token: *token}
+	stored.Plaintext = ""
+	s.db.tokens[string(token.Hash)] = &stored
+
+	return nil
+}
+
+func (s memoryTokenStore) Insert(ctx context.Context, token *Token) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.insert(token)
+}
+
+// insertPair mirrors the Postgres insertPair. The caller must hold db.mu.
+func (s memoryTokenStore) insertPair(userID int64, family string, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.Family = family
+
+		err = s.insert(token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+func (s memoryTokenStore) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	family, err := randomString()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.insertPair(userID, family, accessTTL, refreshTTL, userAgent)
+}
+
+func (s memoryTokenStore) Rotate(ctx context.Context, refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.tokens[string(hashToken(refreshPlaintext))]
+	if !ok || token.Scope != ScopeRefresh || !token.Expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	if token.rotatedAt != nil {
+		s.deleteFamily(token.Family)
+		return nil, nil, ErrTokenReused
+	}
+
+	now := memoryNow()
+	token.rotatedAt = &now
+
+	return s.insertPair(token.UserID, token.Family, accessTTL, refreshTTL, userAgent)
+}
+
+// deleteFamily removes every token in the family. The caller must hold db.mu.
+func (s memoryTokenStore) deleteFamily(family string) {
+	for hash, token := range s.db.tokens {
+		if token.Family == family {
+			delete(s.db.tokens, hash)
+		}
+	}
+}
+
+func (s memoryTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for hash, token := range s.db.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(s.db.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (s memoryTokenStore) DeleteExpired(ctx context.Context, batchSize int) (int64, error) {
+	if batchSize < 1 {
+		return 0, errInvalidBatchSize
+	}
+
+	var total int64
+
+	for {
+		deleted := s.deleteExpiredBatch(batchSize)
+		total += deleted
+
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+func (s memoryTokenStore) deleteExpiredBatch(batchSize int) int64 {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := memoryNow()
+
+	var deleted int64
+
+	for hash, token := range s.db.tokens {
+		if deleted == int64(batchSize) {
+			break
+		}
+
+		if token.Expiry.Before(now) {
+			delete(s.db.tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+func (s memoryTokenStore) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := string(hashToken(tokenPlaintext))
+
+	token, ok := s.db.tokens[hash]
+	if !ok || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+
+	if token.Family != "" {
+		s.deleteFamily(token.Family)
+	}
+
+	delete(s.db.tokens, hash)
+
+	return nil
+}
+
+func (s memoryTokenStore) DeleteOtherSessions(ctx context.Context, userID int64, tokenPlaintext string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := string(hashToken(tokenPlaintext))
+
+	var family string
+	if current, ok := s.db.tokens[hash]; ok && current.Scope == ScopeAuthentication {
+		family = current.Family
+	}
+
+	for key, token := range s.db.tokens {
+		if token.UserID != userID || (token.Scope != ScopeAuthentication && token.Scope != ScopeRefresh) {
+			continue
+		}
+
+		if key == hash || (family != "" && token.Family == family) {
+			continue
+		}
+
+		delete(s.db.tokens, key)
+	}
+
+	return nil
+}
+
+func (s memoryTokenStore) Touch(ctx context.Context, tokenPlaintext string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.tokens[string(hashToken(tokenPlaintext))]
+	if !ok {
+		return nil
+	}
+
+	now := memoryNow()
+
+	if token.LastUsedAt == nil || token.LastUsedAt.Before(now.Add(-time.Minute)) {
+		token.LastUsedAt = &now
+	}
+
+	return nil
+}
+
+func (s memoryTokenStore) GetAllForUser(ctx context.Context, scope string, userID int64) ([]*Token, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	tokens := []*Token{}
+	now := time.Now()
+
+	for _, token := range s.db.tokens {
+		if token.Scope == scope && token.UserID == userID && token.Expiry.After(now) {
+			found := token.Token
+			tokens = append(tokens, &found)
+		}
+	}
+
+	lastActive := func(token *Token) time.Time {
+		if token.LastUsedAt != nil {
+			return *token.LastUsedAt
+		}
+		return token.CreatedAt
+	}
+
+	slices.SortFunc(tokens, func(a, b *Token) int {
+		return lastActive(b).Compare(lastActive(a))
+	})
+
+	return tokens, nil
+}
+
+type memoryLoginFailureStore struct {
+	db *memoryDB
+}
+
+func (s memoryLoginFailureStore) Get(ctx context.Context, key string) (*LoginFailures, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	failures, ok := s.db.logins[key]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *failures
+	return &found, nil
+}
+
+func (s memoryLoginFailureStore) Reserve(ctx context.Context, key string, maxFailures int, backoff, lockout time.Duration) (*LoginFailures, bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := memoryNow()
+
+	failures, ok := s.db.logins[key]
+	if ok && failures.Wait(maxFailures, backoff, lockout, now) > 0 {
+		found := *failures
+		return &found, false, nil
+	}
+
+	if !ok || failures.LastFailureAt.Before(now.Add(-lockout)) {
+		failures = &LoginFailures{}
+		s.db.logins[key] = failures
+	}
+
+	failures.Count++
+	failures.LastFailureAt = now
+	failures.LockedUntil = nil
+
+	if failures.Count >= maxFailures {
+		lockedUntil := now.Add(lockout)
+		failures.LockedUntil = &lockedUntil
+	}
+
+	found := *failures
+	return &found, true, nil
+}
+
+func (s memoryLoginFailureStore) Release(ctx context.Context, key string, maxFailures int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	failures, ok := s.db.logins[key]
+	if !ok || failures.Count == 0 {
+		return nil
+	}
+
+	failures.Count--
+	if failures.Count < maxFailures {
+		failures.LockedUntil = nil
+	}
+
+	return nil
+}
+
+func (s memoryLoginFailureStore) Delete(ctx context.Context, key string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.logins, key)
+	return nil
+}
+
+func (s memoryLoginFailureStore) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var deleted int64
+
+	for key, failures := range s.db.logins {
+		if failures.LastFailureAt.Before(before) && (failures.LockedUntil == nil || failures.LockedUntil.Before(before)) {
+			delete(s.db.logins, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryAuditStore struct {
+	db *memoryDB
+}
+
+func (s memoryAuditStore) Insert(ctx context.Context, entry *AuditEntry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	entry.ID = s.db.nextID("audit")
+	entry.CreatedAt = memoryNow()
+
+	stored := *entry
+	s.db.audit[entry.ID] = &stored
+
+	return nil
+}
+
+func (s memoryAuditStore) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*AuditEntry, Metadata, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	entries := []*AuditEntry{}
+
+	for _, entry := range s.db.audit {
+		if entry.UserID != nil && *entry.UserID == userID {
+			found := *entry
+			entries = append(entries, &found)
+		}
+	}
+
+	id := func(entry *AuditEntry) int64 { return entry.ID }
+
+	sortKey := func(entry *AuditEntry, column string) string {
+		switch column {
+		case "id":
+			return intSortKey(entry.ID)
+		case "created_at":
+			return timeSortKey(&entry.CreatedAt)
+		}
+
+		panic("unknown audit entry sort column: " + column)
+	}
+
+	return memoryPage(entries, filters, id, sortKey)
+}
+
+// memoryRateLimitStore keeps the buckets in a map of its own, since they
+// aren't rows of any model and are shared by every limiter in the process.
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewMemoryRateLimitStore returns a rate limit store that keeps its buckets
+// in memory. Each process has its own, so a limit applies per instance.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryRateLimitStore) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, found := s.buckets[key]
+	if !found {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	var allowed bool
+	bucket.tokens, allowed = limit.take(bucket.tokens, now.Sub(bucket.updatedAt))
+	bucket.updatedAt = now
+
+	return limit.result(bucket.tokens, allowed), nil
+}
+
+func (s *memoryRateLimitStore) Peek(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := float64(limit.Burst)
+
+	if bucket, found := s.buckets[key]; found {
+		tokens = limit.refill(bucket.tokens, now.Sub(bucket.updatedAt))
+	}
+
+	return limit.result(tokens, tokens >= 1), nil
+}
+
+func (s *memoryRateLimitStore) DeleteIdle(ctx context.Context, idle time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+
+	for key, bucket := range s.buckets {
+		if time.Since(bucket.updatedAt) > idle {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
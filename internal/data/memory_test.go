@@ -0,0 +1,444 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Hashing a password is deliberately slow, so every test user shares the
+// same hash.
+var testPassword = sync.OnceValues(func() (password, error) {
+	var p password
+	err := p.Set("pa55word1234")
+	return p, err
+})
+
+func newTestUser(t *testing.T, models Models, name string) *User {
+	t.Helper()
+
+	hash, err := testPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &User{Username: name, Email: name + "@example.com", Password: hash, Activated: true}
+
+	err = models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func newTestPost(t *testing.T, models Models, userID int64, title string) *Post {
+	t.Helper()
+
+	post := &Post{UserID: userID, Title: title, Content: "content of " + title, Status: PostStatusPublished}
+
+	err := models.Posts.Insert(context.Background(), post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return post
+}
+
+func TestMemoryUsersDuplicateEmail(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	newTestUser(t, models, "alice")
+
+	user := &User{Username: "alice2", Email: "alice@example.com"}
+	user.Password.Set("pa55word1234")
+
+	err := models.Users.Insert(ctx, user)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("got %v; want ErrDuplicateEmail", err)
+	}
+
+	user = &User{Username: "alice", Email: "other@example.com"}
+	user.Password.Set("pa55word1234")
+
+	err = models.Users.Insert(ctx, user)
+	if !errors.Is(err, ErrDuplicateUsername) {
+		t.Fatalf("got %v; want ErrDuplicateUsername", err)
+	}
+}
+
+func TestMemoryPostsEditConflict(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	stale := *post
+
+	post.Title = "second"
+	err := models.Posts.Update(ctx, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Title = "third"
+	err = models.Posts.Update(ctx, &stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Fatalf("got %v; want ErrEditConflict", err)
+	}
+
+	revisions, _, err := models.Revisions.GetAllForPost(ctx, post.ID, Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions; want 2", len(revisions))
+	}
+}
+
+func TestMemoryPostsDeleteAndRestore(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	bob := newTestUser(t, models, "bob")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	comment := &Comment{PostID: post.ID, UserID: bob.ID, Content: "nice"}
+
+	err := models.Comments.Insert(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Posts.Delete(ctx, post.ID, bob.ID, false)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v; want ErrUnauthorized", err)
+	}
+
+	err = models.Posts.Delete(ctx, post.ID, alice.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Comments.Get(ctx, post.ID, comment.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got %v; want the comment to be deleted with its post", err)
+	}
+
+	err = models.Posts.Restore(ctx, post.ID, alice.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Comments.Get(ctx, post.ID, comment.ID)
+	if err != nil {
+		t.Fatalf("got %v; want the comment to be restored with its post", err)
+	}
+}
+
+func TestMemoryCommentsInsertMissingPost(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	err := models.Comments.Insert(ctx, &Comment{PostID: post.ID + 1, UserID: alice.ID, Content: "hello?"})
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a missing post; want ErrRecordNotFound", err)
+	}
+
+	err = models.Posts.Delete(ctx, post.ID, alice.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Comments.Insert(ctx, &Comment{PostID: post.ID, UserID: alice.ID, Content: "hello?"})
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a post in the trash; want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryCommentsRestoreOnDeletedPost(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	comment := &Comment{PostID: post.ID, UserID: alice.ID, Content: "hello"}
+
+	err := models.Comments.Insert(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Comments.Delete(ctx, comment.ID, alice.ID, post.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Posts.Delete(ctx, post.ID, alice.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Comments.Restore(ctx, comment.ID, alice.ID, post.ID, true)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a comment on a post in the trash; want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryCommentsPlaceholders(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	root := &Comment{PostID: post.ID, UserID: alice.ID, Content: "root"}
+
+	err := models.Comments.Insert(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := &Comment{PostID: post.ID, UserID: alice.ID, ParentCommentID: &root.ID, Depth: 1, Content: "reply"}
+
+	err = models.Comments.Insert(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested := &Comment{PostID: post.ID, UserID: alice.ID, ParentCommentID: &reply.ID, Depth: 2, Content: "nested"}
+
+	err = models.Comments.Insert(ctx, nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int64{root.ID, reply.ID} {
+		err := models.Comments.Delete(ctx, id, alice.ID, post.ID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A live comment further down keeps every placeholder above it.
+	for _, id := range []int64{root.ID, reply.ID} {
+		got, err := models.Comments.Get(ctx, post.ID, id)
+		if err != nil {
+			t.Fatalf("got %v for comment %d; want a placeholder", err, id)
+		}
+
+		if !got.Deleted || got.ReplyCount != 1 {
+			t.Errorf("got comment %+v; want a placeholder with one reply", got)
+		}
+	}
+
+	purged, err := models.Comments.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 0 {
+		t.Fatalf("got %d comments purged; want the placeholders kept", purged)
+	}
+
+	err = models.Comments.Delete(ctx, nested.ID, alice.ID, post.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int64{root.ID, reply.ID, nested.ID} {
+		_, err := models.Comments.Get(ctx, post.ID, id)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got %v for comment %d without live replies; want ErrRecordNotFound", err, id)
+		}
+	}
+
+	purged, err = models.Comments.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 3 {
+		t.Errorf("got %d comments purged; want 3", purged)
+	}
+}
+
+func TestMemoryUsersPurgeKeepsReplies(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	bob := newTestUser(t, models, "bob")
+	carol := newTestUser(t, models, "carol")
+	post := newTestPost(t, models, alice.ID, "first")
+
+	parent := &Comment{PostID: post.ID, UserID: bob.ID, Content: "first!"}
+	lonely := &Comment{PostID: post.ID, UserID: bob.ID, Content: "anyone?"}
+
+	for _, comment := range []*Comment{parent, lonely} {
+		err := models.Comments.Insert(ctx, comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reply := &Comment{PostID: post.ID, UserID: carol.ID, ParentCommentID: &parent.ID, Depth: 1, Content: "welcome"}
+
+	err := models.Comments.Insert(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Delete(ctx, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := models.Users.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 1 {
+		t.Fatalf("got %d users purged; want 1", purged)
+	}
+
+	got, err := models.Comments.Get(ctx, post.ID, reply.ID)
+	if err != nil {
+		t.Fatalf("got %v for the reply; want it kept", err)
+	}
+
+	if got.Content != "welcome" || got.ParentCommentID == nil || *got.ParentCommentID != parent.ID {
+		t.Errorf("got reply %+v; want it unchanged", got)
+	}
+
+	got, err = models.Comments.Get(ctx, post.ID, parent.ID)
+	if err != nil {
+		t.Fatalf("got %v for the parent; want a placeholder", err)
+	}
+
+	if !got.Deleted || got.UserID != 0 || got.Content != DeletedCommentContent {
+		t.Errorf("got parent %+v; want an anonymous placeholder", got)
+	}
+
+	_, err = models.Comments.Get(ctx, post.ID, lonely.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a comment without replies; want ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryPagination(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	alice := newTestUser(t, models, "alice")
+	for i := 1; i <= 5; i++ {
+		newTestPost(t, models, alice.ID, fmt.Sprintf("post %d", i))
+	}
+
+	filters := Filters{Page: 2, PageSize: 2, Sort: "-id", SortSafelist: []string{"id", "-id"}}
+
+	posts, metadata, err := models.Posts.GetAll(ctx, 0, "", "", 0, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 2 || posts[0].ID != 3 || posts[1].ID != 2 {
+		t.Fatalf("got %v; want posts 3 and 2", posts)
+	}
+
+	if metadata.CurrentPage != 2 || metadata.LastPage != 3 || metadata.TotalRecords != 5 {
+		t.Fatalf("got %+v; want page 2 of 3 with 5 records", metadata)
+	}
+
+	if metadata.NextCursor == "" || metadata.PrevCursor == "" {
+		t.Fatalf("got %+v; want both cursors", metadata)
+	}
+
+	filters.After = metadata.NextCursor
+
+	posts, metadata, err = models.Posts.GetAll(ctx, 0, "", "", 0, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 1 || posts[0].ID != 1 || metadata.NextCursor != "" {
+		t.Fatalf("got %v, %+v; want only post 1 and no next cursor", posts, metadata)
+	}
+
+	filters.After, filters.Before = "", metadata.PrevCursor
+
+	posts, _, err = models.Posts.GetAll(ctx, 0, "", "", 0, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 2 || posts[0].ID != 3 || posts[1].ID != 2 {
+		t.Fatalf("got %v; want posts 3 and 2", posts)
+	}
+}
+
+func TestMemoryTagsMerge(t *testing.T) {
+	models := NewMemoryModels()
+	ctx := context.Background()
+
+	user := newTestUser(t, models, "alice")
+	first := newTestPost(t, models, user.ID, "first")
+	second := newTestPost(t, models, user.ID, "second")
+
+	golang, gopher := &Tag{Name: "golang"}, &Tag{Name: "go"}
+
+	for _, tag := range []*Tag{golang, gopher} {
+		err := models.Tags.Insert(ctx, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first post has both tags, so merging must not tag it twice.
+	for _, pt := range [][2]int64{{first.ID, golang.ID}, {first.ID, gopher.ID}, {second.ID, golang.ID}} {
+		err := models.PostTags.Insert(ctx, pt[0], pt[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := models.Tags.Merge(ctx, golang.ID, gopher.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Tags.Get(ctx, golang.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for the merged tag; want ErrRecordNotFound", err)
+	}
+
+	filters := Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}}
+
+	for _, post := range []*Post{first, second} {
+		tags, _, err := models.Tags.GetAllForPost(ctx, post.ID, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tags) != 1 || tags[0].ID != gopher.ID {
+			t.Errorf("got %d tags on post %d; want only the target tag", len(tags), post.ID)
+		}
+	}
+
+	err = models.Tags.Merge(ctx, golang.ID, gopher.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v merging a deleted tag; want ErrRecordNotFound", err)
+	}
+}
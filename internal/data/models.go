@@ -1,33 +1,70 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// DefaultQueryTimeout is used by NewModels when no query timeout is given.
+const DefaultQueryTimeout = 3 * time.Second
+
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
 	ErrUnauthorized   = errors.New("user is not authorized to perform this action")
 	ErrDuplicateEntry = errors.New("duplicate entry")
+
+	errInvalidBatchSize = errors.New("batch size must be greater than zero")
 )
 
 type Models struct {
-	Posts    PostModel
-	Users    UserModel
-	Comments CommentModel
-	Tags     TagModel
-	PostTags PostTagModel
-	Tokens   TokenModel
+	Posts         PostStore
+	Revisions     PostRevisionStore
+	Users         UserStore
+	Comments      CommentStore
+	Tags          TagStore
+	PostTags      PostTagStore
+	Tokens        TokenStore
+	LoginFailures LoginFailureStore
+	Audit         AuditStore
+	RateLimits    RateLimitStore
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns models whose queries are cancelled when the caller's
+// context ends or after queryTimeout, whichever comes first.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	if queryTimeout <= 0 {
+		queryTimeout = DefaultQueryTimeout
+	}
+
 	return Models{
-		Posts:    PostModel{DB: db},
-		Users:    UserModel{DB: db},
-		Comments: CommentModel{DB: db},
-		Tags:     TagModel{DB: db},
-		PostTags: PostTagModel{DB: db},
-		Tokens:   TokenModel{DB: db},
+		Posts:         PostModel{DB: db, Timeout: queryTimeout},
+		Revisions:     PostRevisionModel{DB: db, Timeout: queryTimeout},
+		Users:         UserModel{DB: db, Timeout: queryTimeout},
+		Comments:      CommentModel{DB: db, Timeout: queryTimeout},
+		Tags:          TagModel{DB: db, Timeout: queryTimeout},
+		PostTags:      PostTagModel{DB: db, Timeout: queryTimeout},
+		Tokens:        TokenModel{DB: db, Timeout: queryTimeout},
+		LoginFailures: LoginFailureModel{DB: db, Timeout: queryTimeout},
+		Audit:         AuditModel{DB: db, Timeout: queryTimeout},
+		RateLimits:    RateLimitModel{DB: db, Timeout: queryTimeout},
 	}
 }
+
+// IsQueryCanceled reports whether err came from a query that was stopped
+// because its context ended, either by hitting the query timeout or because
+// the caller gave up.
+func IsQueryCanceled(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	// lib/pq cancels the statement on the server, which reports it as
+	// query_canceled.
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"slices"
+
+	"github.com/manuelam2003/blogly/internal/validator"
+)
+
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleAuthor    = "author"
+	RoleReader    = "reader"
+)
+
+const (
+	PermissionPostsWrite       = "posts:write"
+	PermissionPostsModerate    = "posts:moderate"
+	PermissionCommentsWrite    = "comments:write"
+	PermissionCommentsModerate = "comments:moderate"
+	PermissionTagsWrite        = "tags:write"
+	PermissionUsersManage      = "users:manage"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+var rolePermissions = map[string]Permissions{
+	RoleAdmin: {
+		PermissionPostsWrite,
+		PermissionPostsModerate,
+		PermissionCommentsWrite,
+		PermissionCommentsModerate,
+		PermissionTagsWrite,
+		PermissionUsersManage,
+	},
+	RoleModerator: {
+		PermissionPostsWrite,
+		PermissionPostsModerate,
+		PermissionCommentsWrite,
+		PermissionCommentsModerate,
+		PermissionTagsWrite,
+	},
+	RoleAuthor: {
+		PermissionPostsWrite,
+		PermissionCommentsWrite,
+	},
+	RoleReader: {
+		PermissionCommentsWrite,
+	},
+}
+
+var Roles = []string{RoleAdmin, RoleModerator, RoleAuthor, RoleReader}
+
+func PermissionsForRole(role string) Permissions {
+	return rolePermissions[role]
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(role, Roles...), "role", "must be one of admin, moderator, author or reader")
+}
@@ -21,17 +21,21 @@ func ValidatePostTag(v *validator.Validator, postTag *PostTag) {
 }
 
 type PostTagModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (p PostTagModel) Insert(postID, tagID int64) error {
+func (p PostTagModel) Insert(ctx context.Context, postID, tagID int64) error {
 	checkQuery := `
         SELECT COUNT(*) 
         FROM post_tags 
         WHERE post_id = $1 AND tag_id = $2`
 
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
 	var count int
-	err := p.DB.QueryRow(checkQuery, postID, tagID).Scan(&count)
+	err := p.DB.QueryRowContext(ctx, checkQuery, postID, tagID).Scan(&count)
 	if err != nil {
 		return err
 	}
@@ -44,9 +48,6 @@ func (p PostTagModel) Insert(postID, tagID int64) error {
         INSERT INTO post_tags (post_id, tag_id)
         VALUES ($1, $2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	_, err = p.DB.ExecContext(ctx, insertQuery, postID, tagID)
 	if err != nil {
 		return err
@@ -55,12 +56,12 @@ func (p PostTagModel) Insert(postID, tagID int64) error {
 	return nil
 }
 
-func (pt *PostTagModel) Delete(postID, tagID int64) error {
+func (pt PostTagModel) Delete(ctx context.Context, postID, tagID int64) error {
 	query := `
 		DELETE FROM post_tags
 		WHERE post_id = $1 AND tag_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, pt.Timeout)
 	defer cancel()
 
 	result, err := pt.DB.ExecContext(ctx, query, postID, tagID)
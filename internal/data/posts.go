@@ -10,13 +10,27 @@ import (
 	"github.com/manuelam2003/blogly/internal/validator"
 )
 
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+	PostStatusArchived  = "archived"
+)
+
 type Post struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Title     string    `json:"title,omitempty"`
-	Content   string    `json:"content,omitempty"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Title     string     `json:"title,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func (p *Post) IsPublished() bool {
+	return p.Status == PostStatusPublished
 }
 
 func ValidatePost(v *validator.Validator, post *Post) {
@@ -25,39 +39,71 @@ func ValidatePost(v *validator.Validator, post *Post) {
 
 	v.Check(post.Content != "", "content", "must be provided")
 	v.Check(len(post.Content) <= 3000, "content", "must not be more than 3000 bytes long")
+
+	v.Check(validator.PermittedValue(post.Status, PostStatusDraft, PostStatusScheduled, PostStatusPublished, PostStatusArchived), "status", "must be one of draft, scheduled, published or archived")
+
+	if post.Status == PostStatusScheduled {
+		v.Check(post.PublishAt != nil, "publish_at", "must be provided for scheduled posts")
+	}
+}
+
+// ValidatePublishAt checks that a scheduled post is due in the future. It is
+// only checked when the schedule is set, since a post whose time has come
+// stays scheduled, and editable, until the publisher gets to it.
+func ValidatePublishAt(v *validator.Validator, post *Post) {
+	if post.Status == PostStatusScheduled && post.PublishAt != nil {
+		v.Check(post.PublishAt.After(time.Now()), "publish_at", "must be in the future")
+	}
 }
 
 type PostModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (p PostModel) Insert(post *Post) error {
+func (p PostModel) Insert(ctx context.Context, post *Post) error {
 	query := `
-		INSERT INTO posts(user_id, title, content)
-		VALUES ($1, $2, $3)
+		INSERT INTO posts(user_id, title, content, status, publish_at)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, updated_at
 	`
-	args := []any{post.UserID, post.Title, post.Content}
+	args := []any{post.UserID, post.Title, post.Content, post.Status, post.PublishAt}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, tx, post)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (p PostModel) Get(id int64) (*Post, error) {
+func (p PostModel) Get(ctx context.Context, id int64) (*Post, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, user_id, title, content, created_at, updated_at
+		SELECT id, user_id, title, content, status, publish_at, created_at, updated_at
 		FROM posts
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	var post Post
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
 	defer cancel()
 
 	err := p.DB.QueryRowContext(ctx, query, id).Scan(
@@ -65,6 +111,8 @@ func (p PostModel) Get(id int64) (*Post, error) {
 		&post.UserID,
 		&post.Title,
 		&post.Content,
+		&post.Status,
+		&post.PublishAt,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 	)
@@ -81,20 +129,26 @@ func (p PostModel) Get(id int64) (*Post, error) {
 	return &post, nil
 }
 
-func (p PostModel) Update(post *Post) error {
+func (p PostModel) Update(ctx context.Context, post *Post) error {
 	query := `
 		UPDATE posts
-		SET title = $1, content = $2, updated_at = NOW()
-		WHERE id = $3 AND updated_at = $4
+		SET title = $1, content = $2, status = $3, publish_at = $4, updated_at = NOW()
+		WHERE id = $5 AND updated_at = $6 AND deleted_at IS NULL
 		RETURNING updated_at
 	`
 
-	args := []any{post.Title, post.Content, post.ID, post.UpdatedAt}
+	args := []any{post.Title, post.Content, post.Status, post.PublishAt, post.ID, post.UpdatedAt}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&post.UpdatedAt)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&post.UpdatedAt)
 
 	if err != nil {
 		switch {
@@ -105,22 +159,37 @@ func (p PostModel) Update(post *Post) error {
 		}
 	}
 
-	return nil
+	err = insertRevision(ctx, tx, post)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (p PostModel) Delete(id int64, userID int64) error {
+// Delete moves the post, along with its comments, to the trash if it belongs
+// to userID. When override is set the ownership check is skipped, for users
+// allowed to moderate any post.
+func (p PostModel) Delete(ctx context.Context, id int64, userID int64, override bool) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-        DELETE FROM posts
-        WHERE id = $1 AND user_id = $2`
+        UPDATE posts
+        SET deleted_at = NOW()
+        WHERE id = $1 AND (user_id = $2 OR $3) AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
 	defer cancel()
 
-	result, err := p.DB.ExecContext(ctx, query, id, userID)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id, userID, override)
 	if err != nil {
 		return err
 	}
@@ -134,10 +203,10 @@ func (p PostModel) Delete(id int64, userID int64) error {
 		existsQuery := `
             SELECT COUNT(*) 
             FROM posts 
-            WHERE id = $1`
+            WHERE id = $1 AND deleted_at IS NULL`
 
 		var count int
-		err := p.DB.QueryRowContext(ctx, existsQuery, id).Scan(&count)
+		err := tx.QueryRowContext(ctx, existsQuery, id).Scan(&count)
 		if err != nil {
 			return err
 		}
@@ -150,23 +219,191 @@ func (p PostModel) Delete(id int64, userID int64) error {
 		}
 	}
 
-	return nil
+	// NOW() is fixed for the whole transaction, so the comments share the
+	// post's deleted_at and can be restored together with it.
+	commentsQuery := `
+        UPDATE comments
+        SET deleted_at = NOW()
+        WHERE post_id = $1 AND deleted_at IS NULL`
+
+	_, err = tx.ExecContext(ctx, commentsQuery, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Restore takes the post, and the comments deleted along with it, out of the
+// trash. The same ownership rules as Delete apply.
+func (p PostModel) Restore(ctx context.Context, id int64, userID int64, override bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        SELECT user_id
+        FROM posts
+        WHERE id = $1 AND deleted_at IS NOT NULL
+        FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID int64
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&ownerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if ownerID != userID && !override {
+		return ErrUnauthorized
+	}
+
+	commentsQuery := `
+        UPDATE comments
+        SET deleted_at = NULL
+        WHERE post_id = $1 AND deleted_at = (SELECT deleted_at FROM posts WHERE id = $1)`
+
+	_, err = tx.ExecContext(ctx, commentsQuery, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE posts SET deleted_at = NULL WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p PostModel) GetDeletedForUser(ctx context.Context, userID int64, filters Filters) ([]*Post, Metadata, error) {
+	args := []any{userID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s, %s, id, user_id, title, content, status, publish_at, created_at, updated_at, deleted_at
+	FROM posts
+	WHERE user_id = $1 AND deleted_at IS NOT NULL
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
+
+	args = append(args, page.args...)
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	posts := []*Post{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var (
+			post Post
+			key  cursorKey
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Value,
+			&post.ID,
+			&post.UserID,
+			&post.Title,
+			&post.Content,
+			&post.Status,
+			&post.PublishAt,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.DeletedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = post.ID
+		posts = append(posts, &post)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	posts, metadata := paginate(posts, keys, filters, totalRecords)
+
+	return posts, metadata, nil
+}
+
+// PurgeDeleted permanently removes posts that were moved to the trash before
+// the given time, and returns how many were removed.
+func (p PostModel) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM posts
+		WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
-func (p PostModel) GetAll(userID int64, title, content string, filters Filters) ([]*Post, Metadata, error) {
+// GetAll lists published posts, plus any unpublished posts written by
+// viewerID. Anonymous viewers should pass a viewerID of 0.
+func (p PostModel) GetAll(ctx context.Context, userID int64, title, content string, viewerID int64, filters Filters) ([]*Post, Metadata, error) {
+	args := []any{title, content, userID, viewerID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, user_id, title, content, created_at, updated_at
+	SELECT %s, %s, id, user_id, title, content, status, publish_at, created_at, updated_at
 	FROM posts
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	AND (to_tsvector('simple',content) @@ plainto_tsquery('simple',$2) OR $2 = '')
 	AND (user_id = $3 OR $3 = 0)
-	ORDER BY %s %s, id ASC
-	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+	AND (status = 'published' OR user_id = $4)
+	AND deleted_at IS NULL
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args = append(args, page.args...)
 
-	args := []any{title, content, userID, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
 
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -177,16 +414,23 @@ func (p PostModel) GetAll(userID int64, title, content string, filters Filters)
 
 	totalRecords := 0
 	posts := []*Post{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
-		var post Post
+		var (
+			post Post
+			key  cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&post.ID,
 			&post.UserID,
 			&post.Title,
 			&post.Content,
+			&post.Status,
+			&post.PublishAt,
 			&post.CreatedAt,
 			&post.UpdatedAt,
 		)
@@ -195,30 +439,42 @@ func (p PostModel) GetAll(userID int64, title, content string, filters Filters)
 			return nil, Metadata{}, err
 		}
 
+		key.ID = post.ID
 		posts = append(posts, &post)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	posts, metadata := paginate(posts, keys, filters, totalRecords)
 
 	return posts, metadata, nil
 }
 
-func (p PostModel) GetAllForUser(userID int64, filters Filters) ([]*Post, Metadata, error) {
+func (p PostModel) GetAllForUser(ctx context.Context, userID int64, viewerID int64, filters Filters) ([]*Post, Metadata, error) {
+	args := []any{userID, viewerID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, user_id, title, content, created_at, updated_at
+	SELECT %s, %s, id, user_id, title, content, status, publish_at, created_at, updated_at
 	FROM posts
 	WHERE (user_id = $1 OR $1 = 0)
-	ORDER BY %s %s, id ASC
-	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	AND (status = 'published' OR user_id = $2)
+	AND deleted_at IS NULL
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args = append(args, page.args...)
 
-	args := []any{userID, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
 
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -229,16 +485,23 @@ func (p PostModel) GetAllForUser(userID int64, filters Filters) ([]*Post, Metada
 
 	totalRecords := 0
 	posts := []*Post{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
-		var post Post
+		var (
+			post Post
+			key  cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&post.ID,
 			&post.UserID,
 			&post.Title,
 			&post.Content,
+			&post.Status,
+			&post.PublishAt,
 			&post.CreatedAt,
 			&post.UpdatedAt,
 		)
@@ -247,14 +510,35 @@ func (p PostModel) GetAllForUser(userID int64, filters Filters) ([]*Post, Metada
 			return nil, Metadata{}, err
 		}
 
+		key.ID = post.ID
 		posts = append(posts, &post)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	posts, metadata := paginate(posts, keys, filters, totalRecords)
 
 	return posts, metadata, nil
 }
+
+// PublishScheduled publishes every scheduled post whose publish_at has passed
+// and returns how many posts were published.
+func (p PostModel) PublishScheduled(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE posts
+		SET status = 'published', updated_at = NOW()
+		WHERE status = 'scheduled' AND publish_at <= NOW() AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RateLimit is a token bucket that holds up to Burst tokens and refills at
+// Rate tokens per second. Every request takes a token.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again, and RetryAfter how
+	// long until the next request would be allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago. The
+// Postgres store does the same in SQL.
+func (l RateLimit) refill(tokens float64, elapsed time.Duration) float64 {
+	return min(float64(l.Burst), tokens+max(elapsed.Seconds(), 0)*l.Rate)
+}
+
+// take refills a bucket that held tokens elapsed ago and takes a token from
+// it if there is one, returning the tokens left.
+func (l RateLimit) take(tokens float64, elapsed time.Duration) (float64, bool) {
+	tokens = l.refill(tokens, elapsed)
+
+	if tokens < 1 {
+		return tokens, false
+	}
+
+	return tokens - 1, true
+}
+
+func (l RateLimit) result(tokens float64, allowed bool) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     l.Burst,
+		Remaining: max(int(tokens), 0),
+		Reset:     l.refillTime(float64(l.Burst) - tokens),
+	}
+
+	if !allowed {
+		result.RetryAfter = l.refillTime(1 - tokens)
+	}
+
+	return result
+}
+
+// refillTime returns how long it takes to add n tokens to a bucket.
+func (l RateLimit) refillTime(n float64) time.Duration {
+	if n <= 0 || l.Rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(n / l.Rate * float64(time.Second))
+}
+
+// FillTime returns how long an empty bucket takes to fill up. A bucket that
+// hasn't been used for that long is full, and can be forgotten.
+func (l RateLimit) FillTime() time.Duration {
+	return l.refillTime(float64(l.Burst))
+}
+
+// RateLimitModel keeps the buckets in the rate_limits table, so that every
+// instance of the application shares them.
+type RateLimitModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Allow takes a token from the bucket for key, creating a full one if there
+// is none. The row is locked by the upsert, so concurrent requests for the
+// same key are applied one after the other.
+func (m RateLimitModel) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	query := `
+        INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at)
+        VALUES ($1, GREATEST($2::double precision - 1, 0), $2 >= 1, clock_timestamp())
+        ON CONFLICT (key) DO UPDATE
+        SET (tokens, allowed, updated_at) = (
+            SELECT CASE WHEN refilled >= 1 THEN refilled - 1 ELSE refilled END,
+                refilled >= 1,
+                GREATEST(b.updated_at, clock_timestamp())
+            FROM (
+                SELECT LEAST($2::double precision,
+                    b.tokens + $3 * GREATEST(EXTRACT(EPOCH FROM clock_timestamp() - b.updated_at)::double precision, 0)) AS refilled
+            ) AS refill
+        )
+        RETURNING tokens, allowed`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+
+	err := m.DB.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return limit.result(tokens, allowed), nil
+}
+
+// Peek reports whether the bucket for key has a token left, without taking
+// it. A key without a bucket has a full one.
+func (m RateLimitModel) Peek(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	query := `
+        SELECT COALESCE((
+            SELECT LEAST($2::double precision,
+                tokens + $3 * GREATEST(EXTRACT(EPOCH FROM clock_timestamp() - updated_at)::double precision, 0))
+            FROM rate_limits
+            WHERE key = $1
+        ), $2::double precision)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var tokens float64
+
+	err := m.DB.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return limit.result(tokens, tokens >= 1), nil
+}
+
+// DeleteIdle removes the buckets that haven't been used for the idle period.
+func (m RateLimitModel) DeleteIdle(ctx context.Context, idle time.Duration) (int64, error) {
+	query := `
+        DELETE FROM rate_limits
+        WHERE updated_at < clock_timestamp() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, idle.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestRateLimitTake(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 4}
+
+	tests := []struct {
+		name        string
+		tokens      float64
+		elapsed     time.Duration
+		wantTokens  float64
+		wantAllowed bool
+	}{
+		{name: "full", tokens: 4, wantTokens: 3, wantAllowed: true},
+		{name: "last token", tokens: 1, wantTokens: 0, wantAllowed: true},
+		{name: "empty", tokens: 0.5, wantTokens: 0.5, wantAllowed: false},
+		{name: "refilled", tokens: 0, elapsed: 750 * time.Millisecond, wantTokens: 0.5, wantAllowed: true},
+		{name: "refill is capped at burst", tokens: 0, elapsed: time.Hour, wantTokens: 3, wantAllowed: true},
+		{name: "clock going backwards", tokens: 0.5, elapsed: -time.Second, wantTokens: 0.5, wantAllowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, allowed := limit.take(tt.tokens, tt.elapsed)
+			if tokens != tt.wantTokens || allowed != tt.wantAllowed {
+				t.Errorf("got %v tokens, allowed %t; want %v, %t", tokens, allowed, tt.wantTokens, tt.wantAllowed)
+			}
+		})
+	}
+
+	result := limit.result(0.5, false)
+	if result.Remaining != 0 || result.Reset != 1750*time.Millisecond || result.RetryAfter != 250*time.Millisecond {
+		t.Errorf("got %+v; want 0 remaining, reset in 1.75s and retry after 250ms", result)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, NewMemoryRateLimitStore())
+}
+
+// TestPostgresRateLimitStore needs a database with the migrations applied,
+// given by BLOGLY_TEST_DB_DSN.
+func TestPostgresRateLimitStore(t *testing.T) {
+	dsn := os.Getenv("BLOGLY_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("BLOGLY_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("DELETE FROM rate_limits WHERE key LIKE 'test:%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRateLimitStore(t, RateLimitModel{DB: db, Timeout: DefaultQueryTimeout})
+}
+
+// testRateLimitStore has several instances take tokens from the same buckets
+// at once, and checks that the limit holds across all of them.
+func testRateLimitStore(t *testing.T, store RateLimitStore) {
+	t.Helper()
+
+	const (
+		instances = 4
+		requests  = 10
+	)
+
+	ctx := context.Background()
+	limit := RateLimit{Rate: 0.001, Burst: 5}
+	key := "test:" + t.Name()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range requests {
+				result, err := store.Allow(ctx, key, limit)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if result.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if allowed != limit.Burst {
+		t.Errorf("got %d requests allowed across %d instances; want %d", allowed, instances, limit.Burst)
+	}
+
+	result, err := store.Peek(ctx, key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Allowed || result.Remaining != 0 {
+		t.Errorf("got %+v when peeking; want the bucket to be empty", result)
+	}
+
+	result, err = store.Peek(ctx, key+":peeked", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Allowed || result.Remaining != limit.Burst {
+		t.Errorf("got %+v when peeking at a new key; want a full bucket", result)
+	}
+
+	result, err = store.Allow(ctx, key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 {
+		t.Errorf("got %+v; want the bucket to be empty", result)
+	}
+
+	result, err = store.Allow(ctx, key+":peeked", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Allowed || result.Remaining != limit.Burst-1 {
+		t.Errorf("got %+v for another key; want a full bucket", result)
+	}
+
+	deleted, err := store.DeleteIdle(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 0 {
+		t.Errorf("got %d buckets deleted; want none to be idle", deleted)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = store.DeleteIdle(ctx, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = store.Allow(ctx, key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Allowed || result.Remaining != limit.Burst-1 {
+		t.Errorf("got %+v after deleting idle buckets; want a full bucket", result)
+	}
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PostRevision struct {
+	ID        int64     `json:"id"`
+	PostID    int64     `json:"post_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Text renders the revision as the document compared by revision diffs.
+func (r *PostRevision) Text() string {
+	return r.Title + "\n\n" + r.Content + "\n"
+}
+
+type PostRevisionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// insertRevision records the post's current title and content as a new
+// revision, unless they are unchanged since the latest revision (for example
+// when only the status of the post changed).
+func insertRevision(ctx context.Context, tx *sql.Tx, post *Post) error {
+	query := `
+		INSERT INTO post_revisions (post_id, title, content, created_at)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1 FROM (
+				SELECT title, content
+				FROM post_revisions
+				WHERE post_id = $1
+				ORDER BY id DESC
+				LIMIT 1
+			) latest
+			WHERE latest.title = $2 AND latest.content = $3
+		)`
+
+	_, err := tx.ExecContext(ctx, query, post.ID, post.Title, post.Content, post.UpdatedAt)
+	return err
+}
+
+func (m PostRevisionModel) Get(ctx context.Context, postID, revisionID int64) (*PostRevision, error) {
+	if revisionID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, post_id, title, content, created_at
+		FROM post_revisions
+		WHERE post_id = $1 AND id = $2`
+
+	var revision PostRevision
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, postID, revisionID).Scan(
+		&revision.ID,
+		&revision.PostID,
+		&revision.Title,
+		&revision.Content,
+		&revision.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// GetPrevious returns the revision of the post made just before revisionID.
+func (m PostRevisionModel) GetPrevious(ctx context.Context, postID, revisionID int64) (*PostRevision, error) {
+	query := `
+		SELECT id, post_id, title, content, created_at
+		FROM post_revisions
+		WHERE post_id = $1 AND id < $2
+		ORDER BY id DESC
+		LIMIT 1`
+
+	var revision PostRevision
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, postID, revisionID).Scan(
+		&revision.ID,
+		&revision.PostID,
+		&revision.Title,
+		&revision.Content,
+		&revision.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+func (m PostRevisionModel) GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*PostRevision, Metadata, error) {
+	args := []any{postID}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s, %s, id, post_id, title, content, created_at
+	FROM post_revisions
+	WHERE post_id = $1
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
+
+	args = append(args, page.args...)
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*PostRevision{}
+
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var (
+			revision PostRevision
+			key      cursorKey
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&key.Value,
+			&revision.ID,
+			&revision.PostID,
+			&revision.Title,
+			&revision.Content,
+			&revision.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.ID = revision.ID
+		revisions = append(revisions, &revision)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	revisions, metadata := paginate(revisions, keys, filters, totalRecords)
+
+	return revisions, metadata, nil
+}
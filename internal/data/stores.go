@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The store interfaces describe what the rest of the application needs from
+// each table. The Postgres models implement them, as do the in-memory stores
+// returned by NewMemoryModels.
+
+type PostStore interface {
+	Insert(ctx context.Context, post *Post) error
+	Get(ctx context.Context, id int64) (*Post, error)
+	Update(ctx context.Context, post *Post) error
+	Delete(ctx context.Context, id int64, userID int64, override bool) error
+	Restore(ctx context.Context, id int64, userID int64, override bool) error
+	GetDeletedForUser(ctx context.Context, userID int64, filters Filters) ([]*Post, Metadata, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	GetAll(ctx context.Context, userID int64, title, content string, viewerID int64, filters Filters) ([]*Post, Metadata, error)
+	GetAllForUser(ctx context.Context, userID int64, viewerID int64, filters Filters) ([]*Post, Metadata, error)
+	PublishScheduled(ctx context.Context) (int64, error)
+}
+
+type PostRevisionStore interface {
+	Get(ctx context.Context, postID, revisionID int64) (*PostRevision, error)
+	GetPrevious(ctx context.Context, postID, revisionID int64) (*PostRevision, error)
+	GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*PostRevision, Metadata, error)
+}
+
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByID(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Delete(ctx context.Context, id int64) error
+	Restore(ctx context.Context, id int64) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error)
+}
+
+type CommentStore interface {
+	Get(ctx context.Context, postID, commentID int64) (*Comment, error)
+	GetAllForPost(ctx context.Context, postID int64, rootsOnly bool, filters Filters) ([]*Comment, Metadata, error)
+	GetReplies(ctx context.Context, parentIDs []int64) ([]*Comment, error)
+	GetAllByUser(ctx context.Context, userID, viewerID int64, filters Filters) ([]*Comment, Metadata, error)
+	Insert(ctx context.Context, comment *Comment) error
+	Update(ctx context.Context, comment *Comment) error
+	Delete(ctx context.Context, id, userID, postID int64, override bool) error
+	Restore(ctx context.Context, id, userID, postID int64, override bool) error
+	GetDeletedByUser(ctx context.Context, userID int64, filters Filters) ([]*Comment, Metadata, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+}
+
+type TagStore interface {
+	Insert(ctx context.Context, tag *Tag) error
+	Get(ctx context.Context, id int64) (*Tag, error)
+	Update(ctx context.Context, tag *Tag) error
+	Delete(ctx context.Context, id int64) error
+	Merge(ctx context.Context, sourceID, targetID int64) error
+	GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*Tag, Metadata, error)
+	GetAll(ctx context.Context, postID int64, name string, filters Filters) ([]*Tag, Metadata, error)
+}
+
+type PostTagStore interface {
+	Insert(ctx context.Context, postID, tagID int64) error
+	Delete(ctx context.Context, postID, tagID int64) error
+}
+
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error)
+	Rotate(ctx context.Context, refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error)
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteOtherSessions(ctx context.Context, userID int64, tokenPlaintext string) error
+	DeleteExpired(ctx context.Context, batchSize int) (int64, error)
+	Delete(ctx context.Context, scope, tokenPlaintext string) error
+	Touch(ctx context.Context, tokenPlaintext string) error
+	GetAllForUser(ctx context.Context, scope string, userID int64) ([]*Token, error)
+}
+
+type LoginFailureStore interface {
+	Get(ctx context.Context, key string) (*LoginFailures, error)
+	Reserve(ctx context.Context, key string, maxFailures int, backoff, lockout time.Duration) (*LoginFailures, bool, error)
+	Release(ctx context.Context, key string, maxFailures int) error
+	Delete(ctx context.Context, key string) error
+	DeleteStale(ctx context.Context, before time.Time) (int64, error)
+}
+
+type AuditStore interface {
+	Insert(ctx context.Context, entry *AuditEntry) error
+	GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*AuditEntry, Metadata, error)
+}
+
+// RateLimitStore keeps the token buckets of the rate limiters. Keys from
+// different limiters must not collide.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+	Peek(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+	DeleteIdle(ctx context.Context, idle time.Duration) (int64, error)
+}
+
+var (
+	_ PostStore         = PostModel{}
+	_ PostRevisionStore = PostRevisionModel{}
+	_ UserStore         = UserModel{}
+	_ CommentStore      = CommentModel{}
+	_ TagStore          = TagModel{}
+	_ PostTagStore      = PostTagModel{}
+	_ TokenStore        = TokenModel{}
+	_ LoginFailureStore = LoginFailureModel{}
+	_ AuditStore        = AuditModel{}
+	_ RateLimitStore    = RateLimitModel{}
+)
@@ -23,16 +23,17 @@ func ValidateTag(v *validator.Validator, tag *Tag) {
 }
 
 type TagModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (t TagModel) Insert(tag *Tag) error {
+func (t TagModel) Insert(ctx context.Context, tag *Tag) error {
 	query := `
 		INSERT INTO tags (name)
 		VALUES ($1)
 		RETURNING id, created_at, updated_at`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	err := t.DB.QueryRowContext(ctx, query, tag.Name).Scan(&tag.ID, &tag.CreatedAt, &tag.UpdatedAt)
@@ -48,7 +49,7 @@ func (t TagModel) Insert(tag *Tag) error {
 	return nil
 }
 
-func (t TagModel) Get(id int64) (*Tag, error) {
+func (t TagModel) Get(ctx context.Context, id int64) (*Tag, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -60,7 +61,7 @@ func (t TagModel) Get(id int64) (*Tag, error) {
 
 	var tag Tag
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	err := t.DB.QueryRowContext(ctx, query, id).Scan(
@@ -82,7 +83,7 @@ func (t TagModel) Get(id int64) (*Tag, error) {
 	return &tag, nil
 }
 
-func (t TagModel) Update(tag *Tag) error {
+func (t TagModel) Update(ctx context.Context, tag *Tag) error {
 	query := `
 		UPDATE tags
 		SET name = $1, updated_at = NOW()
@@ -91,7 +92,7 @@ func (t TagModel) Update(tag *Tag) error {
 
 	args := []any{tag.Name, tag.ID, tag.UpdatedAt}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	err := t.DB.QueryRowContext(ctx, query, args...).Scan(&tag.UpdatedAt)
@@ -110,7 +111,7 @@ func (t TagModel) Update(tag *Tag) error {
 	return nil
 }
 
-func (t TagModel) Delete(id int64) error {
+func (t TagModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -119,7 +120,7 @@ func (t TagModel) Delete(id int64) error {
         DELETE FROM tags
         WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	result, err := t.DB.ExecContext(ctx, query, id)
@@ -139,18 +140,71 @@ func (t TagModel) Delete(id int64) error {
 	return nil
 }
 
-func (t TagModel) GetAllOld(name string, filters Filters) ([]*Tag, Metadata, error) {
+// Merge moves the posts tagged with the source tag over to the target tag
+// and deletes the source tag.
+func (t TagModel) Merge(ctx context.Context, sourceID, targetID int64) error {
+	if sourceID < 1 || targetID < 1 || sourceID == targetID {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM tags WHERE id IN ($1, $2)`, sourceID, targetID).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if found != 2 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        INSERT INTO post_tags (post_id, tag_id)
+        SELECT post_id, $2 FROM post_tags WHERE tag_id = $1
+        ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tags WHERE id = $1`, sourceID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (t TagModel) GetAllOld(ctx context.Context, name string, filters Filters) ([]*Tag, Metadata, error) {
+	args := []any{name}
+
+	page, err := filters.pageQuery("", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id,name , created_at, updated_at
+	SELECT %s, %s, id,name , created_at, updated_at
 	FROM tags
 	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	ORDER BY %s %s, id ASC
-	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args = append(args, page.args...)
 
-	args := []any{name, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
 
 	rows, err := t.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -162,11 +216,17 @@ func (t TagModel) GetAllOld(name string, filters Filters) ([]*Tag, Metadata, err
 	totalRecords := 0
 	tags := []*Tag{}
 
+	keys := []cursorKey{}
+
 	for rows.Next() {
-		var tag Tag
+		var (
+			tag Tag
+			key cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&tag.ID,
 			&tag.Name,
 			&tag.CreatedAt,
@@ -177,31 +237,41 @@ func (t TagModel) GetAllOld(name string, filters Filters) ([]*Tag, Metadata, err
 			return nil, Metadata{}, err
 		}
 
+		key.ID = tag.ID
 		tags = append(tags, &tag)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	tags, metadata := paginate(tags, keys, filters, totalRecords)
 
 	return tags, metadata, nil
 }
 
-func (t TagModel) GetAllForPost(postID int64, filters Filters) ([]*Tag, Metadata, error) {
+func (t TagModel) GetAllForPost(ctx context.Context, postID int64, filters Filters) ([]*Tag, Metadata, error) {
+	args := []any{postID}
+
+	page, err := filters.pageQuery("tags.", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), tags.id, tags.name, tags.created_at, tags.updated_at
+	SELECT %s, %s, tags.id, tags.name, tags.created_at, tags.updated_at
 	FROM tags
 	INNER JOIN post_tags ON post_tags.tag_id = tags.id
 	WHERE (post_tags.post_id = $1)
-	ORDER BY %s %s, id ASC
-	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args = append(args, page.args...)
 
-	args := []any{postID, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
 
 	rows, err := t.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -213,11 +283,17 @@ func (t TagModel) GetAllForPost(postID int64, filters Filters) ([]*Tag, Metadata
 	totalRecords := 0
 	tags := []*Tag{}
 
+	keys := []cursorKey{}
+
 	for rows.Next() {
-		var tag Tag
+		var (
+			tag Tag
+			key cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&tag.ID,
 			&tag.Name,
 			&tag.CreatedAt,
@@ -228,19 +304,21 @@ func (t TagModel) GetAllForPost(postID int64, filters Filters) ([]*Tag, Metadata
 			return nil, Metadata{}, err
 		}
 
+		key.ID = tag.ID
 		tags = append(tags, &tag)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	tags, metadata := paginate(tags, keys, filters, totalRecords)
 
 	return tags, metadata, nil
 }
 
-func (t TagModel) GetAll(postID int64, name string, filters Filters) ([]*Tag, Metadata, error) {
+func (t TagModel) GetAll(ctx context.Context, postID int64, name string, filters Filters) ([]*Tag, Metadata, error) {
 	var whereClause string
 	var args []any
 
@@ -252,18 +330,24 @@ func (t TagModel) GetAll(postID int64, name string, filters Filters) ([]*Tag, Me
 		args = append(args, name)
 	}
 
+	page, err := filters.pageQuery("tags.", len(args))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), tags.id, tags.name, tags.created_at, tags.updated_at
+	SELECT %s, %s, tags.id, tags.name, tags.created_at, tags.updated_at
 	FROM tags
 	%s
-	ORDER BY %s %s, tags.id ASC
-	LIMIT $2 OFFSET $3`, whereClause, filters.sortColumn(), filters.sortDirection())
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, whereClause, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	// Add pagination arguments
-	args = append(args, filters.limit(), filters.offset())
+	args = append(args, page.args...)
 
 	rows, err := t.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -275,11 +359,17 @@ func (t TagModel) GetAll(postID int64, name string, filters Filters) ([]*Tag, Me
 	totalRecords := 0
 	tags := []*Tag{}
 
+	keys := []cursorKey{}
+
 	for rows.Next() {
-		var tag Tag
+		var (
+			tag Tag
+			key cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&tag.ID,
 			&tag.Name,
 			&tag.CreatedAt,
@@ -290,14 +380,16 @@ func (t TagModel) GetAll(postID int64, name string, filters Filters) ([]*Tag, Me
 			return nil, Metadata{}, err
 		}
 
+		key.ID = tag.ID
 		tags = append(tags, &tag)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	tags, metadata := paginate(tags, keys, filters, totalRecords)
 
 	return tags, metadata, nil
 }
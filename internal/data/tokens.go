@@ -1,11 +1,13 @@
 package data
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/manuelam2003/blogly/internal/validator"
@@ -14,36 +16,63 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
+var ErrTokenReused = errors.New("refresh token has already been used")
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	Family     string     `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	now := time.Now()
+
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		Expiry:    now.Add(ttl),
+		Scope:     scope,
+		CreatedAt: now,
 	}
 
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = plaintext
+	token.Hash = hashToken(token.Plaintext)
+
+	return token, nil
+}
+
+func randomString() (string, error) {
 	randomBytes := make([]byte, 16)
 
 	_, err := rand.Read(randomBytes)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
 
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+func hashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
 
-	return token, nil
+func (t *Token) Matches(tokenPlaintext string) bool {
+	return bytes.Equal(t.Hash, hashToken(tokenPlaintext))
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -52,41 +81,325 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (t TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (t TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.Insert(token)
+	err = t.Insert(ctx, token)
 	return token, err
 }
 
-func (t TokenModel) Insert(token *Token) error {
+func (t TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	return insertToken(ctx, t.DB, token)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertToken(ctx context.Context, db execer, token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, user_id, expiry, scope, created_at, user_agent, family) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.CreatedAt, token.UserAgent, token.Family}
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// NewPair issues an authentication token and a refresh token that start a new
+// token family. Pairs rotated from the refresh token stay in the same family,
+// so the whole session can be revoked at once.
+func (t TokenModel) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	family, err := randomString()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
-	_, err := t.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertPair(ctx, tx, userID, family, accessTTL, refreshTTL, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
 }
 
-func (t TokenModel) DeleteAllForUser(scope string, userID int64) error {
+// Rotate exchanges a refresh token for a new pair in the same family. The old
+// refresh token is kept, marked as rotated, until it expires; presenting it
+// again means it has leaked, so the whole family is revoked and ErrTokenReused
+// is returned.
+func (t TokenModel) Rotate(ctx context.Context, refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	query := `
+        SELECT user_id, family, rotated_at IS NOT NULL
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3
+        FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID  int64
+		family  string
+		rotated bool
+	)
+
+	hash := hashToken(refreshPlaintext)
+
+	err = tx.QueryRowContext(ctx, query, hash, ScopeRefresh, time.Now()).Scan(&userID, &family, &rotated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if rotated {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = NOW() WHERE hash = $1`, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertPair(ctx, tx, userID, family, accessTTL, refreshTTL, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+func insertPair(ctx context.Context, tx *sql.Tx, userID int64, family string, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.Family = family
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+func (t TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
         DELETE FROM tokens 
         WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
 	defer cancel()
 
 	_, err := t.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteExpired removes the expired tokens of every scope, batchSize rows at
+// a time so that no single statement holds locks on a large part of the
+// table. It returns how many tokens were removed, including when ctx ends
+// part way through.
+func (t TokenModel) DeleteExpired(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+        DELETE FROM tokens
+        WHERE hash IN (
+            SELECT hash FROM tokens
+            WHERE expiry < NOW()
+            LIMIT $1
+        )`
+
+	if batchSize < 1 {
+		return 0, errInvalidBatchSize
+	}
+
+	var total int64
+
+	for {
+		deleted, err := t.deleteBatch(ctx, query, batchSize)
+		total += deleted
+
+		if err != nil || deleted < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+func (t TokenModel) deleteBatch(ctx context.Context, query string, batchSize int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Delete revokes the token along with every other token in its family.
+func (t TokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE (scope = $1 AND hash = $2)
+        OR family IN (
+            SELECT family FROM tokens
+            WHERE scope = $1 AND hash = $2 AND family <> ''
+        )`
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, scope, hashToken(tokenPlaintext))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteOtherSessions revokes the user's authentication and refresh tokens,
+// except for the given authentication token and the rest of its family.
+func (t TokenModel) DeleteOtherSessions(ctx context.Context, userID int64, tokenPlaintext string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $1 AND scope IN ($2, $3) AND hash <> $4
+        AND family NOT IN (
+            SELECT family FROM tokens
+            WHERE scope = $2 AND hash = $4 AND family <> ''
+        )`
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, hashToken(tokenPlaintext))
+	return err
+}
+
+func (t TokenModel) Touch(ctx context.Context, tokenPlaintext string) error {
+	// Only record usage once a minute so that every authenticated request
+	// doesn't turn into a write.
+	query := `
+        UPDATE tokens
+        SET last_used_at = NOW()
+        WHERE hash = $1
+        AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, hashToken(tokenPlaintext))
+	return err
+}
+
+func (t TokenModel) GetAllForUser(ctx context.Context, scope string, userID int64) ([]*Token, error) {
+	query := `
+        SELECT hash, user_id, expiry, scope, created_at, last_used_at, user_agent
+        FROM tokens
+        WHERE scope = $1 AND user_id = $2 AND expiry > $3
+        ORDER BY COALESCE(last_used_at, created_at) DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(
+			&token.Hash,
+			&token.UserID,
+			&token.Expiry,
+			&token.Scope,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
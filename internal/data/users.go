@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -15,18 +14,25 @@ import (
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int64      `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Password  password   `json:"-"`
+	Activated bool       `json:"activated"`
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) Can(code string) bool {
+	return PermissionsForRole(u.Role).Include(code)
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -87,21 +93,27 @@ func ValidateUser(v *validator.Validator, user *User) {
 var (
 	ErrDuplicateEmail    = errors.New("duplicate email")
 	ErrDuplicateUsername = errors.New("duplicate username")
+	ErrLastAdmin         = errors.New("last admin")
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (u UserModel) Insert(user *User) error {
+func (u UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-        INSERT INTO users (username, email, password_hash) 
-        VALUES ($1, $2, $3)
+        INSERT INTO users (username, email, password_hash, activated, role) 
+        VALUES ($1, $2, $3, $4, $5)
         RETURNING id, created_at, updated_at`
 
-	args := []any{user.Username, user.Email, user.Password.hash}
+	if user.Role == "" {
+		user.Role = RoleAuthor
+	}
+
+	args := []any{user.Username, user.Email, user.Password.hash, user.Activated, user.Role}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
@@ -119,15 +131,15 @@ func (u UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (u UserModel) GetByID(id int64) (*User, error) {
+func (u UserModel) GetByID(ctx context.Context, id int64) (*User, error) {
 	query := `
-        SELECT id, username, email, password_hash, created_at, updated_at
+        SELECT id, username, email, password_hash, activated, role, created_at, updated_at
         FROM users
-        WHERE id = $1`
+        WHERE id = $1 AND deleted_at IS NULL`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, id).Scan(
@@ -135,6 +147,8 @@ func (u UserModel) GetByID(id int64) (*User, error) {
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -151,15 +165,15 @@ func (u UserModel) GetByID(id int64) (*User, error) {
 	return &user, nil
 }
 
-func (u UserModel) GetByEmail(email string) (*User, error) {
+func (u UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-        SELECT id, username, email, password_hash, created_at, updated_at
+        SELECT id, username, email, password_hash, activated, role, created_at, updated_at
         FROM users
-        WHERE email = $1`
+        WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, email).Scan(
@@ -167,6 +181,8 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -183,25 +199,73 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (u UserModel) Update(user *User) error {
+// Update saves the user, returning ErrLastAdmin if that would take the admin
+// role away from the only active admin.
+func (u UserModel) Update(ctx context.Context, user *User) error {
+	// The active admins are locked in order, so that two admins demoting each
+	// other at once are applied one after the other, and the second sees
+	// that it would leave no admins.
+	lockAdmins := `
+        SELECT id
+        FROM users
+        WHERE role = 'admin' AND activated AND deleted_at IS NULL
+        AND EXISTS (SELECT 1 FROM users target WHERE target.id = $1 AND target.role = 'admin')
+        ORDER BY id
+        FOR UPDATE`
+
 	query := `
         UPDATE users 
-        SET username = $1, email = $2, password_hash = $3, updated_at = NOW()
-        WHERE id = $4 AND updated_at = $5
+        SET username = $1, email = $2, password_hash = $3, activated = $4, role = $5, updated_at = NOW()
+        WHERE id = $6 AND updated_at = $7 AND deleted_at IS NULL
         RETURNING updated_at`
 
 	args := []any{
 		user.Username,
 		user.Email,
 		user.Password.hash,
+		user.Activated,
+		user.Role,
 		user.ID,
 		user.UpdatedAt,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if user.Role != RoleAdmin {
+		rows, err := tx.QueryContext(ctx, lockAdmins, user.ID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var admins []int64
+
+		for rows.Next() {
+			var id int64
+			err := rows.Scan(&id)
+			if err != nil {
+				return err
+			}
+			admins = append(admins, id)
+		}
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if len(admins) == 1 && admins[0] == user.ID {
+			return ErrLastAdmin
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -213,26 +277,25 @@ func (u UserModel) Update(user *User) error {
 		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
-
+func (u UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	query := `
-        SELECT users.id, users.created_at, users.username, users.email, users.password_hash, users.updated_at
+        SELECT users.id, users.created_at, users.username, users.email, users.password_hash, users.activated, users.role, users.updated_at
         FROM users
         INNER JOIN tokens
         ON users.id = tokens.user_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2 
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND users.deleted_at IS NULL`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{hashToken(tokenPlaintext), tokenScope, time.Now()}
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -241,6 +304,8 @@ func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
 		&user.UpdatedAt,
 	)
 	if err != nil {
@@ -255,19 +320,28 @@ func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	return &user, nil
 }
 
-func (u UserModel) Delete(id int64) error {
+// Delete moves the user to the trash together with the posts and comments
+// they wrote, so that Restore can bring all of it back.
+func (u UserModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM users
-		WHERE id = $1`
+        UPDATE users
+        SET deleted_at = NOW()
+        WHERE id = $1 AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
-	result, err := u.DB.ExecContext(ctx, query, id)
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -281,20 +355,130 @@ func (u UserModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	// NOW() is fixed for the whole transaction, so everything deleted here
+	// shares the user's deleted_at.
+	for _, contentQuery := range []string{
+		`UPDATE posts SET deleted_at = NOW() WHERE user_id = $1 AND deleted_at IS NULL`,
+		`UPDATE comments SET deleted_at = NOW() WHERE post_id IN (SELECT id FROM posts WHERE user_id = $1) AND deleted_at IS NULL`,
+		`UPDATE comments SET deleted_at = NOW() WHERE user_id = $1 AND deleted_at IS NULL`,
+	} {
+		_, err = tx.ExecContext(ctx, contentQuery, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Restore takes the user, and everything deleted along with them, out of the
+// trash.
+func (u UserModel) Restore(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deletedAt time.Time
+
+	err = tx.QueryRowContext(ctx, `SELECT deleted_at FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, id).Scan(&deletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	for _, contentQuery := range []string{
+		`UPDATE posts SET deleted_at = NULL WHERE user_id = $1 AND deleted_at = $2`,
+		`UPDATE comments SET deleted_at = NULL WHERE post_id IN (SELECT id FROM posts WHERE user_id = $1) AND deleted_at = $2`,
+		`UPDATE comments SET deleted_at = NULL WHERE user_id = $1 AND deleted_at = $2`,
+	} {
+		_, err = tx.ExecContext(ctx, contentQuery, id, deletedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET deleted_at = NULL WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (u UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
+// PurgeDeleted permanently removes users that were moved to the trash before
+// the given time, along with everything they wrote, and returns how many
+// users were removed. Their comments that have live replies are kept as
+// anonymous "[deleted]" placeholders, so that other users' replies aren't
+// lost with them.
+func (u UserModel) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	anonymize := `
+        UPDATE comments
+        SET user_id = NULL, content = '` + DeletedCommentContent + `', deleted_at = COALESCE(deleted_at, NOW())
+        WHERE user_id IN (SELECT id FROM users WHERE deleted_at < $1)
+        AND ` + hasLiveReplies("comments")
+
+	query := `
+        DELETE FROM users
+        WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, anonymize, before)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, tx.Commit()
+}
+
+func (u UserModel) GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error) {
+	page, err := filters.pageQuery("", 0)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, username, email, created_at, updated_at
+	SELECT %s, %s, id, username, email, activated, role, created_at, updated_at
 	FROM users
-	ORDER BY %s %s, id ASC
-	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+	WHERE deleted_at IS NULL
+	AND %s
+	ORDER BY %s
+	%s`, page.count, page.key, page.where, page.orderBy, page.limit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
 
-	args := []any{filters.limit(), filters.offset()}
+	args := page.args
 
 	rows, err := u.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -306,14 +490,22 @@ func (u UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
 	totalRecords := 0
 	users := []*User{}
 
+	keys := []cursorKey{}
+
 	for rows.Next() {
-		var user User
+		var (
+			user User
+			key  cursorKey
+		)
 
 		err := rows.Scan(
 			&totalRecords,
+			&key.Value,
 			&user.ID,
 			&user.Username,
 			&user.Email,
+			&user.Activated,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -322,14 +514,16 @@ func (u UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
 			return nil, Metadata{}, err
 		}
 
+		key.ID = user.ID
 		users = append(users, &user)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	users, metadata := paginate(users, keys, filters, totalRecords)
 
 	return users, metadata, nil
 }
@@ -0,0 +1,179 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxCells caps the size of the table lineOps fills in, which needs one cell
+// for every pair of lines left once the common prefix and suffix are
+// trimmed.
+const maxCells = 1 << 20
+
+// ErrTooLarge is returned by Unified when the inputs differ in too many lines
+// to be compared.
+var ErrTooLarge = errors.New("diff: inputs differ in too many lines")
+
+const (
+	opEqual = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind int
+	line string
+	aPos int
+	bPos int
+}
+
+// Unified returns a unified diff of a and b with the given number of context
+// lines around each change. It returns an empty string if a and b are equal.
+// A missing newline at the end of a or b is ignored.
+func Unified(fromName, toName, a, b string, context int) (string, error) {
+	ops, err := lineOps(splitLines(a), splitLines(b))
+	if err != nil {
+		return "", err
+	}
+
+	var changes []int
+	for i, o := range ops {
+		if o.kind != opEqual {
+			changes = append(changes, i)
+		}
+	}
+
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "--- %s\n", fromName)
+	fmt.Fprintf(&sb, "+++ %s\n", toName)
+
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-context, 0)
+
+		// Changes share a hunk when the unchanged lines between them are
+		// all covered by context.
+		last := changes[i]
+		for i++; i < len(changes) && changes[i]-last-1 <= 2*context; i++ {
+			last = changes[i]
+		}
+
+		end := min(last+context+1, len(ops))
+
+		writeHunk(&sb, ops[start:end])
+	}
+
+	return sb.String(), nil
+}
+
+func writeHunk(sb *strings.Builder, ops []op) {
+	aLen, bLen := 0, 0
+	for _, o := range ops {
+		if o.kind != opInsert {
+			aLen++
+		}
+		if o.kind != opDelete {
+			bLen++
+		}
+	}
+
+	aStart, bStart := ops[0].aPos, ops[0].bPos
+	if aLen > 0 {
+		aStart++
+	}
+	if bLen > 0 {
+		bStart++
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			sb.WriteString(" ")
+		case opDelete:
+			sb.WriteString("-")
+		case opInsert:
+			sb.WriteString("+")
+		}
+		sb.WriteString(o.line)
+		sb.WriteString("\n")
+	}
+}
+
+// lineOps computes the edit script turning a into b from the longest common
+// subsequence of their lines. The lines a and b start and end with are
+// matched up front, so that the table only covers the lines in between.
+func lineOps(a, b []string) ([]op, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	rows, cols := len(midA)+1, len(midB)+1
+	if rows*cols > maxCells {
+		return nil, ErrTooLarge
+	}
+
+	lcs := make([]int32, rows*cols)
+
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i*cols+j] = lcs[(i+1)*cols+j+1] + 1
+			} else {
+				lcs[i*cols+j] = max(lcs[(i+1)*cols+j], lcs[i*cols+j+1])
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(a)+len(b)-prefix-suffix)
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{kind: opEqual, line: a[i], aPos: i, bPos: i})
+	}
+
+	i, j := 0, 0
+
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			ops = append(ops, op{kind: opEqual, line: midA[i], aPos: prefix + i, bPos: prefix + j})
+			i++
+			j++
+		case i < len(midA) && (j == len(midB) || lcs[(i+1)*cols+j] >= lcs[i*cols+j+1]):
+			ops = append(ops, op{kind: opDelete, line: midA[i], aPos: prefix + i, bPos: prefix + j})
+			i++
+		default:
+			ops = append(ops, op{kind: opInsert, line: midB[j], aPos: prefix + i, bPos: prefix + j})
+			j++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ai, bi := len(a)-suffix+k, len(b)-suffix+k
+		ops = append(ops, op{kind: opEqual, line: a[ai], aPos: ai, bPos: bi})
+	}
+
+	return ops, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,73 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	const header = "--- old\n+++ new\n"
+
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	edited := "1\nx\n3\n4\n5\n6\n7\ny\n9\n"
+
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		context int
+		want    string
+	}{
+		{name: "identical", a: "a\nb\n", b: "a\nb\n", context: 3, want: ""},
+		{name: "both empty", a: "", b: "", context: 3, want: ""},
+		{name: "empty old", a: "", b: "a\nb\n", context: 3, want: header + "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{name: "empty new", a: "a\nb\n", b: "", context: 3, want: header + "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{name: "insertion", a: "a\nb\nc\n", b: "a\nb\nx\nc\n", context: 1, want: header + "@@ -2,2 +2,3 @@\n b\n+x\n c\n"},
+		{name: "insertion without context", a: "a\nc\n", b: "a\nb\nc\n", context: 0, want: header + "@@ -1,0 +2,1 @@\n+b\n"},
+		{name: "deletion", a: "a\nb\nc\n", b: "a\nc\n", context: 1, want: header + "@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{name: "change without context", a: "a\nb\nc\n", b: "a\nx\nc\n", context: 0, want: header + "@@ -2,1 +2,1 @@\n-b\n+x\n"},
+		{name: "change at start", a: "a\nb\nc\n", b: "x\nb\nc\n", context: 1, want: header + "@@ -1,2 +1,2 @@\n-a\n+x\n b\n"},
+		{name: "change at end", a: "a\nb\nc\n", b: "a\nb\nx\n", context: 1, want: header + "@@ -2,2 +2,2 @@\n b\n-c\n+x\n"},
+		{name: "missing trailing newline is ignored", a: "a\nb", b: "a\nb\n", context: 3, want: ""},
+		{name: "change without trailing newline", a: "a\nb", b: "a\nc", context: 1, want: header + "@@ -1,2 +1,2 @@\n a\n-b\n+c\n"},
+		{name: "separate hunks", a: numbers, b: edited, context: 1, want: header + "@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n@@ -7,3 +7,3 @@\n 7\n-8\n+y\n 9\n"},
+		{name: "hunks joined by context", a: "1\n2\n3\n4\n5\n6\n", b: "1\nx\n3\n4\ny\n6\n", context: 1, want: header + "@@ -1,6 +1,6 @@\n 1\n-2\n+x\n 3\n 4\n-5\n+y\n 6\n"},
+		{name: "merged hunks", a: numbers, b: edited, context: 3, want: header + "@@ -1,9 +1,9 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n 9\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unified("old", "new", tt.a, tt.b, tt.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedTooLarge(t *testing.T) {
+	a := strings.Repeat("a\n", 1500)
+	b := strings.Repeat("b\n", 1500)
+
+	_, err := Unified("old", "new", a, b, 3)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("got error %v; want ErrTooLarge", err)
+	}
+
+	// Lines the inputs share at either end don't count towards the limit.
+	shared := strings.Repeat("same\n", 5000)
+
+	got, err := Unified("old", "new", shared+"x\n"+shared, shared+"y\n"+shared, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "--- old\n+++ new\n@@ -5000,3 +5000,3 @@\n same\n-x\n+y\n same\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,360 @@
+// Package metrics keeps counters, gauges and histograms in memory and writes
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are histogram buckets suited to HTTP request latencies, in
+// seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes every registered metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		err := c.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// floatValue is a float64 that can be added to from several goroutines.
+type floatValue struct {
+	bits atomic.Uint64
+}
+
+func (f *floatValue) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *floatValue) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *floatValue) Value() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+type Counter struct {
+	floatValue
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+type Gauge struct {
+	floatValue
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// family is a metric and its series, one for each combination of label
+// values seen so far.
+type family[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	create func() *T
+	sample func(w io.Writer, name, labels string, series *T) error
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newFamily[T any](name, help, kind string, labels []string, create func() *T) *family[T] {
+	return &family[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		create: create,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+	}
+}
+
+func (f *family[T]) with(values ...string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	series, ok := f.series[key]
+	if !ok {
+		series = f.create()
+		f.series[key] = series
+		f.values[key] = slices.Clone(values)
+	}
+
+	return series
+}
+
+func (f *family[T]) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	f.mu.Unlock()
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		f.mu.Lock()
+		series, values := f.series[key], f.values[key]
+		f.mu.Unlock()
+
+		err := f.sample(w, f.name, formatLabels(f.labels, values), series)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type CounterVec struct {
+	*family[Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	f := newFamily(name, help, "counter", labels, func() *Counter { return &Counter{} })
+	f.sample = func(w io.Writer, name, labels string, c *Counter) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(c.Value()))
+		return err
+	}
+
+	r.register(f)
+
+	return &CounterVec{f}
+}
+
+// With returns the counter for the given label values, in the order the
+// labels were registered.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values...)
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+type GaugeVec struct {
+	*family[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	f := newFamily(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })
+	f.sample = func(w io.Writer, name, labels string, g *Gauge) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(g.Value()))
+		return err
+	}
+
+	r.register(f)
+
+	return &GaugeVec{f}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values...)
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+type HistogramVec struct {
+	*family[Histogram]
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	f := newFamily(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	f.sample = func(w io.Writer, name, labels string, h *Histogram) error {
+		h.mu.Lock()
+		counts, sum, count := slices.Clone(h.counts), h.sum, h.count
+		h.mu.Unlock()
+
+		for i, upper := range buckets {
+			_, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(upper)), counts[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			name, withLabel(labels, "le", "+Inf"), count,
+			name, labels, formatFloat(sum),
+			name, labels, count)
+		return err
+	}
+
+	r.register(f)
+
+	return &HistogramVec{f}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values...)
+}
+
+// funcCollector reports values read at scrape time, for metrics that are
+// kept elsewhere, such as the stats of a sql.DB.
+type funcCollector struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+func (c funcCollector) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", c.name, escapeHelp(c.help), c.name, c.kind, c.name, formatFloat(c.fn()))
+	return err
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(funcCollector{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(funcCollector{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value))
+
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
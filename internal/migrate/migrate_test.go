@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/manuelam2003/blogly/migrations"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int64
+		err      string
+	}{
+		{
+			name: "ordered by version",
+			fsys: fstest.MapFS{
+				"000010_ten.up.sql":   file("SELECT 10;"),
+				"000002_two.up.sql":   file("SELECT 2;"),
+				"000002_two.down.sql": file("SELECT -2;"),
+				"README.md":           file("not a migration"),
+				"migrations.go":       file("package migrations"),
+			},
+			versions: []int64{2, 10},
+		},
+		{
+			name: "empty file",
+			fsys: fstest.MapFS{"000001_empty.up.sql": file(" \n")},
+			err:  "000001_empty.up.sql is empty",
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{"000001_one.down.sql": file("SELECT 1;")},
+			err:  "has no up file",
+		},
+		{
+			name: "two names",
+			fsys: fstest.MapFS{
+				"000001_one.up.sql":   file("SELECT 1;"),
+				"000001_uno.down.sql": file("SELECT 1;"),
+			},
+			err: "has two names",
+		},
+		{
+			name: "version 0",
+			fsys: fstest.MapFS{"000000_zero.up.sql": file("SELECT 0;")},
+			err:  "invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, err := Load(tt.fsys)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v; want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var versions []int64
+			for _, m := range loaded {
+				versions = append(versions, m.Version)
+			}
+
+			if fmt.Sprint(versions) != fmt.Sprint(tt.versions) {
+				t.Errorf("got versions %v; want %v", versions, tt.versions)
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks the migrations shipped in the binary: every
+// version from 1 up is there, with both directions.
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for i, m := range loaded {
+		if m.Version != int64(i+1) {
+			t.Errorf("got version %d at position %d; want %d", m.Version, i, i+1)
+		}
+
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+// TestMigrator runs the embedded migrations up and down in a schema of its
+// own in the database given by BLOGLY_TEST_DB_DSN.
+func TestMigrator(t *testing.T) {
+	dsn := os.Getenv("BLOGLY_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("BLOGLY_TEST_DB_DSN is not set")
+	}
+
+	ctx := context.Background()
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	db, err := sql.Open("postgres", withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := migrator.Check(ctx)
+	if err != nil || pending != len(migrator.Migrations()) {
+		t.Fatalf("got %d pending, %v; want all %d", pending, err, len(migrator.Migrations()))
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(migrator.Migrations()) {
+		t.Errorf("got %d migrations applied; want %d", len(applied), len(migrator.Migrations()))
+	}
+
+	applied, err = migrator.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("got %d applied, %v on the second run; want none", len(applied), err)
+	}
+
+	reverted, err := migrator.Down(ctx, 2)
+	if err != nil || len(reverted) != 2 {
+		t.Fatalf("got %d reverted, %v; want 2", len(reverted), err)
+	}
+
+	version, _, err := migrator.Version(ctx)
+	if err != nil || version != migrator.Latest()-2 {
+		t.Errorf("got version %d, %v; want %d", version, err, migrator.Latest()-2)
+	}
+
+	_, err = migrator.Down(ctx, len(migrator.Migrations()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, _, err = migrator.Version(ctx)
+	if err != nil || version != 0 {
+		t.Errorf("got version %d, %v after reverting everything; want 0", version, err)
+	}
+
+	_, err = db.Exec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", migrator.Latest()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Check(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got %v; want ErrSchemaTooNew", err)
+	}
+
+	_, err = migrator.Up(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got %v from Up; want ErrSchemaTooNew", err)
+	}
+}
+
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated bool NOT NULL DEFAULT false;
+
+-- Accounts created before activation existed are treated as already activated.
+UPDATE users SET activated = true;
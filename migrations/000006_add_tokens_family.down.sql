@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tokens_family;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family;
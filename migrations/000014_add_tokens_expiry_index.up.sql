@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_tokens_expiry ON tokens(expiry);